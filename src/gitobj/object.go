@@ -0,0 +1,126 @@
+package gitobj
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Commit struct {
+	Hash       Hash
+	Tree       Hash
+	Parents    []Hash
+	AuthorTime time.Time
+	Message    string
+}
+
+func (self *Repo) ReadCommit(hash Hash) (*Commit, error) {
+	data, err := self.readTyped(hash, OBJ_COMMIT)
+	if err != nil {
+		return nil, err
+	}
+	commit := &Commit{Hash: hash}
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl == -1 {
+			nl = len(data)
+		}
+		line := string(data[0:nl])
+		data = data[nl:]
+		if len(data) > 0 {
+			data = data[1:]
+		}
+		if line == "" {
+			commit.Message = string(data)
+			break
+		}
+		sp := strings.IndexByte(line, ' ')
+		if sp == -1 {
+			continue
+		}
+		key, value := line[0:sp], line[sp+1:]
+		switch key {
+		case "tree":
+			if commit.Tree, err = ParseHash(value); err != nil {
+				return nil, fmt.Errorf("commit %s: %s", hash, err)
+			}
+		case "parent":
+			parent, err := ParseHash(value)
+			if err != nil {
+				return nil, fmt.Errorf("commit %s: %s", hash, err)
+			}
+			commit.Parents = append(commit.Parents, parent)
+		case "author":
+			if commit.AuthorTime, err = parseSignatureTime(value); err != nil {
+				return nil, fmt.Errorf("commit %s: %s", hash, err)
+			}
+		}
+	}
+	if commit.Tree.IsZero() {
+		return nil, fmt.Errorf("commit %s has no tree", hash)
+	}
+	return commit, nil
+}
+
+/*
+ * "Name <email> 1614578400 +0000" -> time
+ */
+func parseSignatureTime(sig string) (time.Time, error) {
+	gt := strings.LastIndexByte(sig, '>')
+	if gt == -1 {
+		return time.Time{}, fmt.Errorf("bad signature '%s'", sig)
+	}
+	fields := strings.Fields(sig[gt+1:])
+	if len(fields) == 0 {
+		return time.Time{}, fmt.Errorf("bad signature '%s'", sig)
+	}
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad signature '%s'", sig)
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	Hash Hash
+}
+
+func (entry TreeEntry) IsTree() bool {
+	return entry.Mode == 040000
+}
+
+func (self *Repo) ReadTree(hash Hash) ([]TreeEntry, error) {
+	data, err := self.readTyped(hash, OBJ_TREE)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]TreeEntry, 0)
+	for len(data) > 0 {
+		// "<mode> <name>\0<20 byte hash>"
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp == -1 || nul == -1 || sp > nul || nul+21 > len(data) {
+			return nil, fmt.Errorf("tree %s is corrupt", hash)
+		}
+		mode, err := strconv.ParseUint(string(data[0:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("tree %s is corrupt", hash)
+		}
+		entry := TreeEntry{Mode: uint32(mode), Name: string(data[sp+1 : nul])}
+		copy(entry.Hash[:], data[nul+1:nul+21])
+		entries = append(entries, entry)
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
+
+/*
+ * the returned slice may be shared with the object cache, don't modify it
+ */
+func (self *Repo) ReadBlob(hash Hash) ([]byte, error) {
+	return self.readTyped(hash, OBJ_BLOB)
+}
@@ -0,0 +1,349 @@
+package gitobj
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// how many bytes of recently read pack objects to keep around for deltas
+const packCacheMax = 32 << 20
+
+// largest object a pack may declare, anything bigger is taken as corruption
+// (nothing in the xlocate repository comes close)
+const maxObjectSize = 1 << 30
+
+// longest delta chain followed, git doesn't make them longer than this
+// (pack.depth is capped at 4095) so anything deeper is a corrupt pack or a
+// loop of ref deltas
+const maxDeltaDepth = 4095
+
+type cachedObject struct {
+	otype ObjectType
+	data  []byte
+}
+
+type pack struct {
+	path   string
+	idx    *os.File
+	pack   *os.File
+	size   int64
+	count  uint32
+	fanout [256]uint32
+
+	mutex     sync.Mutex
+	cache     map[int64]cachedObject
+	cacheSize int
+}
+
+func openPack(path string) (*pack, error) {
+	self := &pack{path: path, cache: make(map[int64]cachedObject)}
+	var err error
+	if self.idx, err = os.Open(path + ".idx"); err != nil {
+		return nil, err
+	}
+	if self.pack, err = os.Open(path + ".pack"); err != nil {
+		self.close()
+		return nil, err
+	}
+	if err := self.readHeaders(); err != nil {
+		self.close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return self, nil
+}
+
+func (self *pack) readHeaders() error {
+	hdr := make([]byte, 8+256*4)
+	if _, err := self.idx.ReadAt(hdr, 0); err != nil {
+		return err
+	}
+	if !bytes.Equal(hdr[0:4], []byte("\377tOc")) || binary.BigEndian.Uint32(hdr[4:8]) != 2 {
+		return fmt.Errorf("unsupported pack index version")
+	}
+	for i := range self.fanout {
+		self.fanout[i] = binary.BigEndian.Uint32(hdr[8+i*4:])
+	}
+	self.count = self.fanout[255]
+
+	phdr := make([]byte, 12)
+	if _, err := self.pack.ReadAt(phdr, 0); err != nil {
+		return err
+	}
+	if !bytes.Equal(phdr[0:4], []byte("PACK")) {
+		return fmt.Errorf("bad pack signature")
+	}
+	if v := binary.BigEndian.Uint32(phdr[4:8]); v != 2 && v != 3 {
+		return fmt.Errorf("unsupported pack version %d", v)
+	}
+	st, err := self.pack.Stat()
+	if err != nil {
+		return err
+	}
+	self.size = st.Size()
+	return nil
+}
+
+func (self *pack) close() {
+	if self.idx != nil {
+		self.idx.Close()
+	}
+	if self.pack != nil {
+		self.pack.Close()
+	}
+}
+
+const idxHashesOff = 8 + 256*4
+
+func (self *pack) hashAt(i uint32) (Hash, error) {
+	var hash Hash
+	_, err := self.idx.ReadAt(hash[:], idxHashesOff+int64(i)*20)
+	return hash, err
+}
+
+/*
+ * find the index of the first object whose name is >= hash
+ */
+func (self *pack) search(hash Hash) uint32 {
+	lo := uint32(0)
+	if hash[0] > 0 {
+		lo = self.fanout[hash[0]-1]
+	}
+	hi := self.fanout[hash[0]]
+	n := sort.Search(int(hi-lo), func(i int) bool {
+		h, err := self.hashAt(lo + uint32(i))
+		return err != nil || bytes.Compare(h[:], hash[:]) >= 0
+	})
+	return lo + uint32(n)
+}
+
+func (self *pack) offsetOf(i uint32) (int64, error) {
+	buf := make([]byte, 8)
+	off4 := idxHashesOff + int64(self.count)*24 + int64(i)*4
+	if _, err := self.idx.ReadAt(buf[0:4], off4); err != nil {
+		return 0, err
+	}
+	off := binary.BigEndian.Uint32(buf[0:4])
+	if off&0x80000000 == 0 {
+		return int64(off), nil
+	}
+	off8 := idxHashesOff + int64(self.count)*28 + int64(off&0x7fffffff)*8
+	if _, err := self.idx.ReadAt(buf, off8); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
+
+func (self *pack) find(hash Hash) (int64, bool) {
+	i := self.search(hash)
+	if i >= self.count {
+		return 0, false
+	}
+	if h, err := self.hashAt(i); err != nil || h != hash {
+		return 0, false
+	}
+	off, err := self.offsetOf(i)
+	if err != nil {
+		return 0, false
+	}
+	return off, true
+}
+
+func (self *pack) getCached(off int64) (cachedObject, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	obj, ok := self.cache[off]
+	return obj, ok
+}
+
+func (self *pack) putCached(off int64, obj cachedObject) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if len(obj.data) > packCacheMax/4 {
+		return
+	}
+	if self.cacheSize+len(obj.data) > packCacheMax {
+		self.cache = make(map[int64]cachedObject)
+		self.cacheSize = 0
+	}
+	self.cache[off] = obj
+	self.cacheSize += len(obj.data)
+}
+
+/*
+ * read the object at offset off in the pack, resolving deltas
+ * depth is how many deltas deep this is
+ */
+func (self *pack) readAt(repo *Repo, off int64, depth int) (ObjectType, []byte, error) {
+	if obj, ok := self.getCached(off); ok {
+		return obj.otype, obj.data, nil
+	}
+	if depth > maxDeltaDepth {
+		return 0, nil, fmt.Errorf("%s: delta chain at %d is too long", self.path, off)
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(self.pack, off, self.size-off))
+
+	// type and inflated size
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	otype := ObjectType((c >> 4) & 7)
+	size := int64(c & 15)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		if shift > 56 {
+			return 0, nil, fmt.Errorf("%s: bad object size at %d", self.path, off)
+		}
+		size |= int64(c&0x7f) << shift
+	}
+	if size < 0 || size > maxObjectSize {
+		return 0, nil, fmt.Errorf("%s: object at %d is too big (%d bytes)", self.path, off, size)
+	}
+
+	var baseType ObjectType
+	var base []byte
+	switch otype {
+	case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+		// not a delta
+	case OBJ_OFS_DELTA:
+		if c, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		neg := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			neg = ((neg + 1) << 7) | int64(c&0x7f)
+		}
+		if neg <= 0 || neg > off {
+			return 0, nil, fmt.Errorf("%s: bad delta offset at %d", self.path, off)
+		}
+		if baseType, base, err = self.readAt(repo, off-neg, depth+1); err != nil {
+			return 0, nil, err
+		}
+	case OBJ_REF_DELTA:
+		var hash Hash
+		if _, err := io.ReadFull(br, hash[:]); err != nil {
+			return 0, nil, err
+		}
+		if baseType, base, err = repo.readObject(hash, depth+1); err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, fmt.Errorf("%s: bad object type %d at %d", self.path, otype, off)
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %s", self.path, err)
+	}
+	data, err := readAll(zr, size)
+	zr.Close()
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %s", self.path, err)
+	}
+	if int64(len(data)) != size {
+		return 0, nil, fmt.Errorf("%s: object at %d has the wrong size", self.path, off)
+	}
+
+	if base != nil {
+		if data, err = applyDelta(base, data); err != nil {
+			return 0, nil, fmt.Errorf("%s: object at %d: %s", self.path, off, err)
+		}
+		otype = baseType
+	}
+
+	self.putCached(off, cachedObject{otype, data})
+	return otype, data, nil
+}
+
+/*
+ * returns -1 if the number doesn't fit in maxObjectSize
+ */
+func deltaVarint(delta []byte) (int, []byte) {
+	n, shift := int64(0), uint(0)
+	for len(delta) > 0 {
+		c := delta[0]
+		delta = delta[1:]
+		if shift > 56 {
+			return -1, delta
+		}
+		n |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	if n < 0 || n > maxObjectSize {
+		return -1, delta
+	}
+	return int(n), delta
+}
+
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, delta := deltaVarint(delta)
+	dstSize, delta := deltaVarint(delta)
+	if srcSize != len(base) {
+		return nil, fmt.Errorf("delta base has the wrong size")
+	}
+	if dstSize < 0 {
+		return nil, fmt.Errorf("delta result is too big")
+	}
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 != 0 {
+			// copy from base
+			var off, size int64
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, fmt.Errorf("truncated delta")
+				}
+				if i < 4 {
+					off |= int64(delta[0]) << (8 * i)
+				} else {
+					size |= int64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if off+size > int64(len(base)) {
+				return nil, fmt.Errorf("delta copy out of bounds")
+			}
+			out = append(out, base[off:off+size]...)
+		} else if op != 0 {
+			// insert literal bytes
+			if int(op) > len(delta) {
+				return nil, fmt.Errorf("truncated delta")
+			}
+			out = append(out, delta[0:op]...)
+			delta = delta[op:]
+		} else {
+			return nil, fmt.Errorf("bad delta opcode")
+		}
+		if len(out) > dstSize {
+			return nil, fmt.Errorf("delta result has the wrong size")
+		}
+	}
+	if len(out) != dstSize {
+		return nil, fmt.Errorf("delta result has the wrong size")
+	}
+	return out, nil
+}
@@ -0,0 +1,137 @@
+package gitobj
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+ * write a repo with a pack holding one ref delta whose base is itself
+ */
+func makeLoopRepo(t *testing.T) (string, Hash) {
+	dir := t.TempDir()
+	packDir := filepath.Join(dir, "objects", "pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var hash Hash
+	hash[0] = 0x42
+
+	var delta bytes.Buffer
+	zw := zlib.NewWriter(&delta)
+	zw.Write([]byte{1, 1, 1, 'x'})
+	zw.Close()
+
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(1))
+	pack.WriteByte(byte(OBJ_REF_DELTA)<<4 | 4) // type and size
+	pack.Write(hash[:])
+	pack.Write(delta.Bytes())
+	pack.Write(make([]byte, 20)) // checksum, not checked
+
+	var idx bytes.Buffer
+	idx.WriteString("\377tOc")
+	binary.Write(&idx, binary.BigEndian, uint32(2))
+	for i := 0; i < 256; i++ {
+		n := uint32(0)
+		if i >= int(hash[0]) {
+			n = 1
+		}
+		binary.Write(&idx, binary.BigEndian, n)
+	}
+	idx.Write(hash[:])
+	binary.Write(&idx, binary.BigEndian, uint32(0))  // crc32
+	binary.Write(&idx, binary.BigEndian, uint32(12)) // offset
+
+	base := filepath.Join(packDir, "pack-loop")
+	if err := ioutil.WriteFile(base+".pack", pack.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(base+".idx", idx.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, hash
+}
+
+func TestRefDeltaLoop(t *testing.T) {
+	dir, hash := makeLoopRepo(t)
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	_, _, err = repo.ReadObject(hash)
+	if err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Errorf("expected a delta chain error, got %v", err)
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("0123456789abcdef")
+	tests := []struct {
+		name  string
+		delta []byte
+		want  string // empty if it should fail
+	}{
+		{"copy all", []byte{16, 16, 0x90, 16}, "0123456789abcdef"},
+		{"copy middle", []byte{16, 4, 0x91, 10, 4}, "abcd"},
+		{"insert", []byte{16, 3, 3, 'x', 'y', 'z'}, "xyz"},
+		{"copy and insert", []byte{16, 6, 0x90, 3, 3, 'x', 'y', 'z'}, "012xyz"},
+		{"copy with offset", []byte{16, 5, 0x91, 12, 4, 1, '!'}, "cdef!"},
+		{"wrong base size", []byte{15, 3, 3, 'x', 'y', 'z'}, ""},
+		{"copy past end", []byte{16, 4, 0x91, 14, 4}, ""},
+		{"offset past end", []byte{16, 1, 0x91, 16, 1}, ""},
+		{"huge offset", []byte{16, 1, 0x9f, 0xff, 0xff, 0xff, 0xff, 1}, ""},
+		{"default copy size", []byte{16, 0x80, 0x80, 0x04, 0x80}, ""},
+		{"truncated copy", []byte{16, 4, 0x91, 10}, ""},
+		{"truncated insert", []byte{16, 3, 3, 'x'}, ""},
+		{"opcode 0", []byte{16, 0, 0}, ""},
+		{"result too short", []byte{16, 4, 3, 'x', 'y', 'z'}, ""},
+		{"result too long", []byte{16, 2, 3, 'x', 'y', 'z'}, ""},
+		{"result too big", []byte{16, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, ""},
+	}
+	for _, test := range tests {
+		out, err := applyDelta(base, test.delta)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", test.name, out)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if !bytes.Equal(out, []byte(test.want)) {
+			t.Errorf("%s: got %q, want %q", test.name, out, test.want)
+		}
+	}
+}
+
+func TestDeltaVarint(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want int
+		rest int
+	}{
+		{[]byte{0}, 0, 0},
+		{[]byte{0x7f, 1}, 0x7f, 1},
+		{[]byte{0x80, 0x01}, 0x80, 0},
+		{[]byte{0xff, 0xff, 0x03, 9}, 0xffff, 1},
+		{[]byte{0x80, 0x80, 0x80, 0x80, 0x08}, -1, 0}, // 1<<31
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, -1, 0},
+	}
+	for _, test := range tests {
+		n, rest := deltaVarint(test.in)
+		if n != test.want || len(rest) != test.rest {
+			t.Errorf("deltaVarint(%v) = %d, %d bytes left, want %d, %d bytes left",
+				test.in, n, len(rest), test.want, test.rest)
+		}
+	}
+}
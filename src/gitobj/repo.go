@@ -0,0 +1,226 @@
+/*
+ * minimal read-only access to the objects in a git repository
+ *
+ * supports loose objects and packfiles (index version 2), which is
+ * everything needed to read the xlocate repository without git installed
+ */
+
+package gitobj
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Hash [20]byte
+
+func ParseHash(s string) (Hash, error) {
+	var hash Hash
+	if len(s) != 40 {
+		return hash, fmt.Errorf("invalid object name '%s'", s)
+	}
+	if _, err := hex.Decode(hash[:], []byte(s)); err != nil {
+		return hash, fmt.Errorf("invalid object name '%s'", s)
+	}
+	return hash, nil
+}
+
+func (hash Hash) String() string {
+	return hex.EncodeToString(hash[:])
+}
+
+func (hash Hash) IsZero() bool {
+	return hash == Hash{}
+}
+
+type ObjectType int
+
+const (
+	OBJ_COMMIT    = ObjectType(1)
+	OBJ_TREE      = ObjectType(2)
+	OBJ_BLOB      = ObjectType(3)
+	OBJ_TAG       = ObjectType(4)
+	OBJ_OFS_DELTA = ObjectType(6)
+	OBJ_REF_DELTA = ObjectType(7)
+)
+
+var objectTypeNames = map[string]ObjectType{
+	"commit": OBJ_COMMIT,
+	"tree":   OBJ_TREE,
+	"blob":   OBJ_BLOB,
+	"tag":    OBJ_TAG,
+}
+
+func (otype ObjectType) String() string {
+	for name, t := range objectTypeNames {
+		if t == otype {
+			return name
+		}
+	}
+	return fmt.Sprintf("type %d", int(otype))
+}
+
+type Repo struct {
+	dir   string // the git dir (not the work tree)
+	packs []*pack
+}
+
+/*
+ * open a bare repository or a work tree containing a .git directory
+ */
+func Open(path string) (*Repo, error) {
+	dir := path
+	if st, err := os.Stat(filepath.Join(path, ".git")); err == nil && st.IsDir() {
+		dir = filepath.Join(path, ".git")
+	}
+	if st, err := os.Stat(filepath.Join(dir, "objects")); err != nil || !st.IsDir() {
+		return nil, fmt.Errorf("'%s' is not a git repository", path)
+	}
+	self := &Repo{dir: dir}
+	idxs, err := filepath.Glob(filepath.Join(dir, "objects", "pack", "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		p, err := openPack(strings.TrimSuffix(idx, ".idx"))
+		if err != nil {
+			self.Close()
+			return nil, err
+		}
+		self.packs = append(self.packs, p)
+	}
+	return self, nil
+}
+
+func (self *Repo) Close() error {
+	for _, p := range self.packs {
+		p.close()
+	}
+	self.packs = nil
+	return nil
+}
+
+/*
+ * read an object and resolve any deltas
+ */
+func (self *Repo) ReadObject(hash Hash) (ObjectType, []byte, error) {
+	return self.readObject(hash, 0)
+}
+
+func (self *Repo) readObject(hash Hash, depth int) (ObjectType, []byte, error) {
+	for _, p := range self.packs {
+		if off, ok := p.find(hash); ok {
+			return p.readAt(self, off, depth)
+		}
+	}
+	otype, data, err := self.readLoose(hash)
+	if os.IsNotExist(err) {
+		return 0, nil, fmt.Errorf("object %s not found", hash)
+	}
+	return otype, data, err
+}
+
+func (self *Repo) readTyped(hash Hash, want ObjectType) ([]byte, error) {
+	otype, data, err := self.ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if otype != want {
+		return nil, fmt.Errorf("object %s is a %s, not a %s", hash, otype, want)
+	}
+	return data, nil
+}
+
+func (self *Repo) loosePath(hash Hash) string {
+	s := hash.String()
+	return filepath.Join(self.dir, "objects", s[0:2], s[2:])
+}
+
+func (self *Repo) readLoose(hash Hash) (ObjectType, []byte, error) {
+	f, err := os.Open(self.loosePath(hash))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, fmt.Errorf("object %s: %s", hash, err)
+	}
+	defer zr.Close()
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return 0, nil, fmt.Errorf("object %s: %s", hash, err)
+	}
+	// "<type> <size>\0<data>"
+	nul := bytes.IndexByte(raw, 0)
+	sp := bytes.IndexByte(raw, ' ')
+	if nul == -1 || sp == -1 || sp > nul {
+		return 0, nil, fmt.Errorf("object %s: bad header", hash)
+	}
+	otype, ok := objectTypeNames[string(raw[0:sp])]
+	if !ok {
+		return 0, nil, fmt.Errorf("object %s: unknown type '%s'", hash, raw[0:sp])
+	}
+	return otype, raw[nul+1:], nil
+}
+
+/*
+ * resolve a ref like "HEAD" or "refs/heads/master" to a hash
+ */
+func (self *Repo) ResolveRef(name string) (Hash, error) {
+	for depth := 0; depth < 10; depth++ {
+		data, err := ioutil.ReadFile(filepath.Join(self.dir, name))
+		if os.IsNotExist(err) {
+			return self.resolvePackedRef(name)
+		} else if err != nil {
+			return Hash{}, err
+		}
+		s := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(s, "ref: ") {
+			return ParseHash(s)
+		}
+		name = strings.TrimPrefix(s, "ref: ")
+	}
+	return Hash{}, fmt.Errorf("too many levels of symbolic refs")
+}
+
+func (self *Repo) resolvePackedRef(name string) (Hash, error) {
+	data, err := ioutil.ReadFile(filepath.Join(self.dir, "packed-refs"))
+	if err != nil && !os.IsNotExist(err) {
+		return Hash{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		// "<hash> <refname>"
+		if sp := strings.IndexByte(line, ' '); sp != -1 && line[sp+1:] == name {
+			return ParseHash(line[0:sp])
+		}
+	}
+	return Hash{}, fmt.Errorf("ref '%s' not found", name)
+}
+
+func (self *Repo) Head() (Hash, error) {
+	return self.ResolveRef("HEAD")
+}
+
+/*
+ * read what should be size bytes, reading at most one more so that the
+ * caller can tell if there was too much
+ */
+func readAll(r io.Reader, size int64) ([]byte, error) {
+	if size < 0 || size > maxObjectSize {
+		return nil, fmt.Errorf("bad object size %d", size)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	_, err := io.Copy(buf, io.LimitReader(r, size+1))
+	return buf.Bytes(), err
+}
@@ -0,0 +1,113 @@
+package gitobj
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(cmd.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=test",
+		"GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test",
+		"GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %s", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+/*
+ * a repository with a few versions of a big file that git gc packs as
+ * deltas, the same way the xlocate repository is stored
+ */
+func makeGcRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git(t, dir, "init", "-q")
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf("pkg-%d-1.0_1:/usr/lib/libpkg%d.so.%d", i, i, i%7))
+	}
+	for c := 0; c < 5; c++ {
+		lines[c*300] = fmt.Sprintf("changed-%d-1.0_1:/usr/bin/changed%d", c, c)
+		content := strings.Join(lines, "\n") + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, "list"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git(t, dir, "add", "list")
+		git(t, dir, "commit", "-q", "-m", fmt.Sprintf("commit %d", c))
+	}
+	git(t, dir, "gc", "-q", "--aggressive")
+	if loose := git(t, dir, "count-objects"); !strings.HasPrefix(loose, "0 objects") {
+		t.Fatalf("objects left unpacked after gc: %s", loose)
+	}
+	return dir
+}
+
+func TestReadGcRepo(t *testing.T) {
+	dir := makeGcRepo(t)
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	if len(repo.packs) == 0 {
+		t.Fatal("no packs found")
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.String() != git(t, dir, "rev-parse", "HEAD") {
+		t.Fatalf("HEAD is %s, git says %s", head, git(t, dir, "rev-parse", "HEAD"))
+	}
+
+	n := 0
+	for hash := head; !hash.IsZero(); n++ {
+		commit, err := repo.ReadCommit(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := git(t, dir, "rev-parse", hash.String()+"^{tree}"); commit.Tree.String() != want {
+			t.Errorf("commit %s: tree is %s, git says %s", hash, commit.Tree, want)
+		}
+		entries, err := repo.ReadTree(commit.Tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name != "list" || entries[0].IsTree() {
+			t.Fatalf("commit %s: unexpected tree %v", hash, entries)
+		}
+		data, err := repo.ReadBlob(entries[0].Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := git(t, dir, "cat-file", "blob", entries[0].Hash.String())
+		if !bytes.Equal(bytes.TrimSpace(data), []byte(want)) {
+			t.Errorf("commit %s: blob %s differs from git cat-file", hash, entries[0].Hash)
+		}
+
+		hash = Hash{}
+		if len(commit.Parents) > 0 {
+			hash = commit.Parents[0]
+		}
+	}
+	if n != 5 {
+		t.Errorf("read %d commits, want 5", n)
+	}
+}
@@ -0,0 +1,159 @@
+package gitobj
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var hexRe = regexp.MustCompile("^[0-9a-f]{4,40}$")
+
+/*
+ * resolve a revision to a commit hash
+ *
+ * accepts "HEAD" (or "@"), a full or abbreviated (4+ digits) hash,
+ * or a ref name like "master" or "refs/heads/master", optionally
+ * followed by "~<n>" or "^" to go back in history
+ */
+func (self *Repo) ResolveRevision(rev string) (Hash, error) {
+	i := strings.IndexAny(rev, "~^")
+	if i == -1 {
+		return self.resolveBase(rev)
+	}
+	hash, err := self.resolveBase(rev[0:i])
+	if err != nil {
+		return hash, err
+	}
+	suffix := rev[i:]
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(suffix[0:digits]); err != nil {
+				return Hash{}, fmt.Errorf("unknown revision '%s'", rev)
+			}
+			suffix = suffix[digits:]
+		}
+		if op != '~' && op != '^' {
+			return Hash{}, fmt.Errorf("unknown revision '%s'", rev)
+		}
+		if op == '^' && n == 0 {
+			continue
+		}
+		// "~<n>": n first parents, "^<n>": the nth parent
+		steps, parent := n, 0
+		if op == '^' {
+			steps, parent = 1, n-1
+		}
+		for ; steps > 0; steps-- {
+			commit, err := self.ReadCommit(hash)
+			if err != nil {
+				return Hash{}, err
+			}
+			if parent >= len(commit.Parents) {
+				return Hash{}, fmt.Errorf("unknown revision '%s'", rev)
+			}
+			hash = commit.Parents[parent]
+		}
+	}
+	return hash, nil
+}
+
+func (self *Repo) resolveBase(rev string) (Hash, error) {
+	if rev == "" || rev == "@" || rev == "HEAD" {
+		return self.Head()
+	}
+	if hexRe.MatchString(rev) {
+		matches := self.findPrefix(rev)
+		if len(matches) == 1 {
+			return matches[0], nil
+		} else if len(matches) > 1 {
+			return Hash{}, fmt.Errorf("short object name '%s' is ambiguous", rev)
+		}
+		// could still be a ref
+	}
+	if !strings.Contains(rev, "..") {
+		for _, name := range []string{rev, "refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev} {
+			if hash, err := self.ResolveRef(name); err == nil {
+				return hash, nil
+			}
+		}
+	}
+	return Hash{}, fmt.Errorf("unknown revision '%s'", rev)
+}
+
+/*
+ * find up to two objects whose names start with the hex string prefix
+ */
+func (self *Repo) findPrefix(prefix string) []Hash {
+	matches := make([]Hash, 0)
+	add := func(hash Hash) {
+		for _, m := range matches {
+			if m == hash {
+				return
+			}
+		}
+		matches = append(matches, hash)
+	}
+
+	var lo Hash
+	padded := prefix + strings.Repeat("0", 40-len(prefix))
+	hex.Decode(lo[:], []byte(padded))
+	for _, p := range self.packs {
+		for i := p.search(lo); i < p.count && len(matches) < 2; i++ {
+			hash, err := p.hashAt(i)
+			if err != nil || !strings.HasPrefix(hash.String(), prefix) {
+				break
+			}
+			add(hash)
+		}
+	}
+
+	dir := filepath.Join(self.dir, "objects", prefix[0:2])
+	if files, err := ioutil.ReadDir(dir); err == nil {
+		for _, file := range files {
+			name := prefix[0:2] + file.Name()
+			if len(matches) >= 2 {
+				break
+			}
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if hash, err := ParseHash(name); err == nil {
+				add(hash)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		fmt.Printf("gitobj: %s\n", err)
+	}
+
+	return matches
+}
+
+/*
+ * follow the first parents from hash until a commit authored at or
+ * before t is found
+ */
+func (self *Repo) FindCommitBefore(hash Hash, t time.Time) (*Commit, error) {
+	for {
+		commit, err := self.ReadCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		if !commit.AuthorTime.After(t) {
+			return commit, nil
+		}
+		if len(commit.Parents) == 0 {
+			return nil, fmt.Errorf("no commits before %s", t.Format(time.RFC3339))
+		}
+		hash = commit.Parents[0]
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+import "xldb"
+
+type api_types struct {
+	Dir  int `json:"dir"`
+	File int `json:"file"`
+	Link int `json:"link"`
+}
+
+type api_owner struct {
+	Pkgver xldb.Pkgver `json:"pkgver"`
+	Type   string      `json:"type"`
+	Target string      `json:"target,omitempty"` // as it is in the package
+	// the path the target points to, empty if it's dangling
+	Resolved string `json:"resolved,omitempty"`
+}
+
+type api_child struct {
+	Name  string    `json:"name"`
+	IsDir bool      `json:"is_dir"`
+	Types api_types `json:"types"`
+}
+
+type api_stat struct {
+	Path     string    `json:"path"`
+	IsDir    bool      `json:"is_dir"`
+	Types    api_types `json:"types"`
+	Children int       `json:"children"`
+}
+
+type api_ls struct {
+	Path     string      `json:"path"`
+	IsDir    bool        `json:"is_dir"`
+	Types    api_types   `json:"types"`
+	Children []api_child `json:"children"`
+}
+
+type api_owners struct {
+	Path   string      `json:"path"`
+	Owners []api_owner `json:"owners"`
+}
+
+type api_error struct {
+	Error string `json:"error"`
+}
+
+func make_api_types(types xldb.VfsTypes) api_types {
+	return api_types{types.Dir, types.File, types.Link}
+}
+
+func make_api_owners(db *xldb.Gen, vfs xldb.Vfs) []api_owner {
+	owners := make([]api_owner, 0)
+	for _, vo := range db.VfsGetOwners(vfs) {
+		owner := api_owner{Pkgver: vo.Pkgver}
+		switch {
+		case vo.Type.IsDir():
+			owner.Type = "dir"
+		case vo.Type.IsFile():
+			owner.Type = "file"
+		default:
+			owner.Type = "link"
+			owner.Target = vo.Type.GetTarget()
+			if tgt := db.VfsLinkResolveTarget(vfs, owner.Target); tgt != xldb.VFS_NONE {
+				owner.Resolved = db.VfsGetPath(tgt)
+			}
+		}
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i1, i2 int) bool {
+		return owners[i1].Pkgver < owners[i2].Pkgver
+	})
+	return owners
+}
+
+func write_api(w http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if req.Method == "HEAD" {
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(v)
+}
+
+/*
+ * /-/api/v1/{stat,ls,owners}?path=<path>
+ * paths are looked up like the browse pages do, without following links
+ */
+func serve_api(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	call := strings.TrimPrefix(req.URL.Path, "/-/api/v1/")
+	switch call {
+	case "stat", "ls", "owners":
+		// ok
+	default:
+		write_api(w, req, http.StatusNotFound, api_error{"unknown api call"})
+		return
+	}
+	path := req.URL.Query().Get("path")
+	if !strings.HasPrefix(path, "/") {
+		write_api(w, req, http.StatusBadRequest, api_error{"path must be absolute"})
+		return
+	}
+
+	db := xd.Get()
+	if !check_last_modified(w, req, db.LastModified) {
+		return
+	}
+	vfs := db.VfsDirFollowPath(xldb.VFS_ROOT, path)
+	if vfs == xldb.VFS_NONE {
+		write_api(w, req, http.StatusNotFound, api_error{"not found"})
+		return
+	}
+	real_path := db.VfsGetPath(vfs)
+
+	switch call {
+	case "stat":
+		write_api(w, req, http.StatusOK, api_stat{
+			Path:     real_path,
+			IsDir:    db.VfsIsDir(vfs, 3),
+			Types:    make_api_types(db.VfsGetTypes(vfs)),
+			Children: len(db.VfsGetChildren(vfs)),
+		})
+	case "ls":
+		children := make([]api_child, 0, len(db.VfsGetChildren(vfs)))
+		for name, cvfs := range db.VfsGetChildren(vfs) {
+			types := db.VfsGetTypes(cvfs)
+			children = append(children, api_child{
+				Name:  name,
+				IsDir: types.Dir > 0 || (types.Link > 0 && db.VfsIsDir(cvfs, 3)),
+				Types: make_api_types(types),
+			})
+		}
+		// same order as print_children
+		sort.Slice(children, func(i1, i2 int) bool {
+			c1, c2 := children[i1], children[i2]
+			if c1.IsDir == c2.IsDir {
+				return c1.Name < c2.Name
+			} else {
+				return c1.IsDir
+			}
+		})
+		write_api(w, req, http.StatusOK, api_ls{
+			Path:     real_path,
+			IsDir:    db.VfsIsDir(vfs, 3),
+			Types:    make_api_types(db.VfsGetTypes(vfs)),
+			Children: children,
+		})
+	case "owners":
+		write_api(w, req, http.StatusOK, api_owners{
+			Path:   real_path,
+			Owners: make_api_owners(db, vfs),
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+import "xldb"
+
+/*
+ * /-/changes: what the last few reloads did
+ */
+func serve_changes(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	if !check_last_modified(w, req, xd.Get().LastModified) {
+		return
+	}
+	changes := xd.GetChanges()
+	h := w.Header()
+	switch request_format(req) {
+	case "json":
+		h.Set("Content-Type", "application/json")
+		if req.Method == "HEAD" {
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(changes)
+	case "txt":
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		if len(changes) == 0 {
+			fmt.Fprintf(w, "no reloads since the server started\n")
+		}
+		for i, cs := range changes {
+			if i > 0 {
+				fmt.Fprintf(w, "\n")
+			}
+			print_changeset(w, cs, false)
+		}
+	default:
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:changes</title>`)
+		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+		if len(changes) == 0 {
+			fmt.Fprintf(w, "no reloads since the server started\n")
+		}
+		for i, cs := range changes {
+			if i > 0 {
+				fmt.Fprintf(w, "\n")
+			}
+			print_changeset(w, cs, true)
+		}
+		fmt.Fprintf(w, `</pre>`)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+import "xldb"
+
+func cli_usage() int {
+	fmt.Fprintf(os.Stderr, "usage: %s [cnf <command> | needs <elf-file> | fromlog <build-log|->]\n", os.Args[0])
+	return 2
+}
+
+/*
+ * voidfs <mode> [args...]: load the tree (the snapshot makes this fast if
+ * it's current), answer one question and exit
+ */
+func run_cli(xd *xldb.Xldb, load func() (*xldb.ChangeSet, error), args []string) int {
+	// keep what xldb says it's doing out of the answer
+	xd.Log = os.Stderr
+
+	switch {
+	case len(args) == 2 && args[0] == "cnf":
+		// fine
+	case len(args) == 2 && args[0] == "needs":
+		// fine
+	case len(args) == 2 && args[0] == "fromlog":
+		// fine
+	default:
+		return cli_usage()
+	}
+
+	if _, err := load(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	db := xd.Get()
+
+	switch args[0] {
+	case "cnf":
+		if !print_cnf(os.Stdout, db, args[1]) {
+			return 127
+		}
+	case "needs":
+		f, err := os.Open(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		defer f.Close()
+		needs, err := db.Needs(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: not an elf file: %s\n", args[1], err)
+			return 1
+		}
+		if !print_needs(os.Stdout, needs) {
+			return 1
+		}
+	case "fromlog":
+		f := os.Stdin
+		if args[1] != "-" {
+			var err error
+			if f, err = os.Open(args[1]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				return 1
+			}
+			defer f.Close()
+		}
+		missing, err := db.FromLog(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[1], err)
+			return 1
+		}
+		if !print_fromlog(os.Stdout, make_fromlog_response(missing)) {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+import "xldb"
+
+/*
+ * print xbps-install suggestions for a command that wasn't found
+ * returns false if no package has it
+ */
+func print_cnf(w io.Writer, db *xldb.Gen, name string) bool {
+	matches := db.FindCommand(name)
+	if len(matches) == 0 {
+		fmt.Fprintf(w, "%s: command not found\n", name)
+		return false
+	}
+
+	// one line per package
+	paths := make(map[string][]string)
+	pkgnames := make([]string, 0)
+	for _, match := range matches {
+		pkgname := match.Pkgver.Name()
+		if _, ok := paths[pkgname]; !ok {
+			pkgnames = append(pkgnames, pkgname)
+		}
+		path := match.Path
+		if match.Type.IsLink() {
+			path += " -> " + match.Type.GetTarget()
+		}
+		paths[pkgname] = append(paths[pkgname], path)
+	}
+	sort.Strings(pkgnames)
+	longest := 0
+	for _, pkgname := range pkgnames {
+		if len(pkgname) > longest {
+			longest = len(pkgname)
+		}
+	}
+
+	fmt.Fprintf(w, "%s: command not found, but it's in these packages:\n", name)
+	sp := strings.Repeat(" ", longest+2)
+	for _, pkgname := range pkgnames {
+		fmt.Fprintf(w, "  xbps-install -S %s%s(%s)\n",
+			pkgname,
+			sp[0:(longest-len(pkgname)+2)],
+			strings.Join(paths[pkgname], ", "))
+	}
+	return true
+}
+
+/*
+ * /-/cnf/<command>: for command_not_found_handle, 404 if nothing has it
+ */
+func serve_cnf(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	db := xd.Get()
+	if !check_last_modified(w, req, db.LastModified) {
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/-/cnf/")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(db.FindCommand(name)) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	if req.Method == "HEAD" {
+		return
+	}
+	print_cnf(w, db, name)
+}
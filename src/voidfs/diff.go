@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+import "xldb"
+
+func short_commit(commit string) string {
+	if len(commit) > 12 {
+		return commit[0:12]
+	}
+	return commit
+}
+
+/*
+ * print a change set as text, or as html with links to the paths at the
+ * commit they exist in
+ */
+func print_changeset(w io.Writer, cs *xldb.ChangeSet, is_html bool) {
+	esc := func(s string) string {
+		if is_html {
+			return html.EscapeString(s)
+		}
+		return s
+	}
+	path_at := func(commit string, path string) string {
+		if !is_html || commit == "" {
+			return path
+		}
+		// each commit is a full load, don't let crawlers walk them all
+		return fmt.Sprintf(`<a href="/@%s%s" rel="nofollow">%s</a>`,
+			html.EscapeString(url.PathEscape(commit)),
+			html.EscapeString((&url.URL{Path: path}).EscapedPath()),
+			html.EscapeString(path))
+	}
+
+	if cs.Loaded != nil {
+		fmt.Fprintf(w, "loaded at %s\n", cs.Loaded.Format(http.TimeFormat))
+	}
+	fmt.Fprintf(w, "%s (%s) -> %s (%s)\n",
+		short_commit(cs.OldCommit), cs.OldLastModified,
+		short_commit(cs.NewCommit), cs.NewLastModified)
+	fmt.Fprintf(w, "%d added, %d updated, %d removed\n",
+		len(cs.Added), len(cs.Updated), len(cs.Removed))
+
+	if len(cs.Added) > 0 {
+		fmt.Fprintf(w, "\n")
+	}
+	for _, change := range cs.Added {
+		fmt.Fprintf(w, "%s: new package (%s)\n",
+			esc(change.Name), esc(change.New.Version()))
+	}
+	if len(cs.Updated) > 0 {
+		fmt.Fprintf(w, "\n")
+	}
+	for _, change := range cs.Updated {
+		fmt.Fprintf(w, "%s: %s -> %s\n",
+			esc(change.Name), esc(change.Old.Version()), esc(change.New.Version()))
+		for _, path := range change.AddedPaths {
+			fmt.Fprintf(w, "  + %s\n", path_at(cs.NewCommit, path))
+		}
+		for _, path := range change.RemovedPaths {
+			fmt.Fprintf(w, "  - %s\n", path_at(cs.OldCommit, path))
+		}
+		for _, link := range change.ChangedLinks {
+			switch {
+			case link.OldTarget == "":
+				fmt.Fprintf(w, "  ~ %s -> %s (was a file)\n",
+					path_at(cs.NewCommit, link.Path), esc(link.NewTarget))
+			case link.NewTarget == "":
+				fmt.Fprintf(w, "  ~ %s (now a file, was -> %s)\n",
+					path_at(cs.NewCommit, link.Path), esc(link.OldTarget))
+			default:
+				fmt.Fprintf(w, "  ~ %s -> %s (was -> %s)\n",
+					path_at(cs.NewCommit, link.Path), esc(link.NewTarget), esc(link.OldTarget))
+			}
+		}
+	}
+	if len(cs.Removed) > 0 {
+		fmt.Fprintf(w, "\n")
+	}
+	for _, change := range cs.Removed {
+		fmt.Fprintf(w, "%s: removed package (%s)\n",
+			esc(change.Name), esc(change.Old.Version()))
+	}
+}
+
+func serve_changeset(w http.ResponseWriter, req *http.Request, cs *xldb.ChangeSet, title string) {
+	h := w.Header()
+	switch request_format(req) {
+	case "json":
+		h.Set("Content-Type", "application/json")
+		if req.Method == "HEAD" {
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(cs)
+	case "txt":
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		print_changeset(w, cs, false)
+	default:
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:%s</title>`, html.EscapeString(title))
+		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+		print_changeset(w, cs, true)
+		fmt.Fprintf(w, `</pre>`)
+	}
+}
+
+/*
+ * /-/diff/<from>..<to> or /-/diff/<to> (compared with its parent)
+ */
+func serve_diff(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	spec := strings.TrimPrefix(req.URL.Path, "/-/diff/")
+	from, to := "", spec
+	if dots := strings.Index(spec, ".."); dots != -1 {
+		from, to = spec[0:dots], spec[dots+2:]
+		if from == "" {
+			serve_error(w, req, http.StatusNotFound, "usage: /-/diff/<from>..<to>")
+			return
+		}
+	}
+	cs, err := xd.Diff(from, to)
+	if err != nil {
+		serve_error(w, req, http.StatusNotFound, err.Error())
+		return
+	}
+	last_modified := cs.NewLastModified
+	t_old, err1 := http.ParseTime(cs.OldLastModified)
+	t_new, err2 := http.ParseTime(cs.NewLastModified)
+	if err1 == nil && err2 == nil && t_old.After(t_new) {
+		last_modified = cs.OldLastModified
+	}
+	if !check_last_modified(w, req, last_modified) {
+		return
+	}
+	serve_changeset(w, req, cs, "diff/"+spec)
+}
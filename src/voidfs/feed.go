@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+import "xldb"
+
+// how many commits to look at for the feed
+const feed_entries = 30
+
+type atom_link struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atom_text struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atom_entry struct {
+	Title   string    `xml:"title"`
+	Id      string    `xml:"id"`
+	Updated string    `xml:"updated"`
+	Link    atom_link `xml:"link"`
+	Content atom_text `xml:"content"`
+}
+
+type atom_feed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	Id      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Author  string       `xml:"author>name"`
+	Link    []atom_link  `xml:"link"`
+	Entries []atom_entry `xml:"entry"`
+}
+
+/*
+ * the scheme and host the client used to reach us
+ */
+func request_base_url(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + req.Host
+}
+
+func atom_time(last_modified string) string {
+	t, err := http.ParseTime(last_modified)
+	if err != nil {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+/*
+ * /-/feed.atom[?pkg=<pkgname>][&path=<path>]
+ */
+func serve_feed(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	if !check_last_modified(w, req, xd.Get().LastModified) {
+		return
+	}
+
+	query := req.URL.Query()
+	pkgname := query.Get("pkg")
+	filter_path := query.Get("path")
+	if filter_path != "" {
+		filter_path = path.Clean("/" + filter_path)
+	}
+
+	changes, err := xd.RecentChanges(feed_entries)
+	if err != nil {
+		// not loaded from git, use what we've seen since starting
+		changes = xd.GetChanges()
+	}
+
+	base := request_base_url(req)
+	self_url := base + req.URL.RequestURI()
+
+	feed := atom_feed{
+		Title:   "voidfs updates",
+		Id:      self_url,
+		Updated: atom_time(xd.Get().LastModified),
+		Author:  progname,
+		Link: []atom_link{
+			{Rel: "self", Href: self_url},
+			{Rel: "alternate", Href: base + "/-/changes"},
+		},
+	}
+	if pkgname != "" {
+		feed.Title += ": " + pkgname
+	}
+	if filter_path != "" {
+		feed.Title += ": " + filter_path
+	}
+
+	for _, cs := range changes {
+		if pkgname != "" || filter_path != "" {
+			if cs = cs.Filter(pkgname, filter_path); cs == nil {
+				continue
+			}
+		}
+		buf := bytes.Buffer{}
+		print_changeset(&buf, cs, false)
+		diff := "/-/diff/" + url.PathEscape(cs.OldCommit) + ".." + url.PathEscape(cs.NewCommit)
+		// the same for every hostname and filter so readers don't show
+		// an update twice
+		id := "urn:voidfs:" + cs.OldCommit + ".." + cs.NewCommit
+		if cs.OldCommit == "" || cs.NewCommit == "" {
+			diff = "/-/changes"
+			id += "@" + atom_time(cs.NewLastModified)
+		}
+		feed.Entries = append(feed.Entries, atom_entry{
+			Title: fmt.Sprintf("%s: %d added, %d updated, %d removed",
+				cs.NewLastModified, len(cs.Added), len(cs.Updated), len(cs.Removed)),
+			Id:      id,
+			Updated: atom_time(cs.NewLastModified),
+			Link:    atom_link{Rel: "alternate", Href: base + diff},
+			Content: atom_text{Type: "text", Text: buf.String()},
+		})
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/atom+xml; charset=utf-8")
+	if req.Method == "HEAD" {
+		return
+	}
+	fmt.Fprintf(w, "%s", xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(feed); err != nil {
+		fmt.Printf("voidfs: feed: %s\n", err)
+	}
+}
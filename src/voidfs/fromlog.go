@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+import "xldb"
+
+// largest log POST /-/fromlog accepts
+const fromlog_max_size = 32 << 20
+
+type fromlog_match struct {
+	Path   string      `json:"path"`
+	Pkgver xldb.Pkgver `json:"pkgver"`
+	Type   string      `json:"type"`
+	Target string      `json:"target,omitempty"`
+}
+
+type fromlog_missing struct {
+	Kind    xldb.LogMissingKind `json:"kind"`
+	Name    string              `json:"name"`
+	Line    int                 `json:"line"`
+	Pkgname string              `json:"pkgname,omitempty"`
+	Matches []fromlog_match     `json:"matches"`
+	Skipped bool                `json:"skipped,omitempty"` // too many to search for
+}
+
+type fromlog_response struct {
+	Missing         []fromlog_missing `json:"missing"`
+	Makedepends     []string          `json:"makedepends"`
+	Hostmakedepends []string          `json:"hostmakedepends"`
+}
+
+func make_fromlog_response(missing []xldb.LogMissing) *fromlog_response {
+	resp := &fromlog_response{
+		Missing:         make([]fromlog_missing, 0, len(missing)),
+		Makedepends:     make([]string, 0),
+		Hostmakedepends: make([]string, 0),
+	}
+	seen := make(map[string]bool)
+	host_seen := make(map[string]bool)
+	for _, m := range missing {
+		fm := fromlog_missing{
+			Kind:    m.Kind,
+			Name:    m.Name,
+			Line:    m.Line,
+			Pkgname: m.Pkgname,
+			Matches: make([]fromlog_match, 0, len(m.Matches)),
+			Skipped: m.Skipped,
+		}
+		for _, match := range m.Matches {
+			fmatch := fromlog_match{Path: match.Path, Pkgver: match.Pkgver, Type: "file"}
+			if match.Type.IsLink() {
+				fmatch.Type = "link"
+				fmatch.Target = match.Type.GetTarget()
+			}
+			fm.Matches = append(fm.Matches, fmatch)
+		}
+		resp.Missing = append(resp.Missing, fm)
+
+		if m.Pkgname == "" {
+			continue
+		}
+		// programs are run on the build machine
+		if m.Kind == xldb.LOG_PROGRAM && !host_seen[m.Pkgname] {
+			host_seen[m.Pkgname] = true
+			resp.Hostmakedepends = append(resp.Hostmakedepends, m.Pkgname)
+		} else if m.Kind != xldb.LOG_PROGRAM && !seen[m.Pkgname] {
+			seen[m.Pkgname] = true
+			resp.Makedepends = append(resp.Makedepends, m.Pkgname)
+		}
+	}
+	sort.Strings(resp.Makedepends)
+	sort.Strings(resp.Hostmakedepends)
+	return resp
+}
+
+/*
+ * print what the log was missing and the template lines to add
+ * returns false if something wasn't found
+ */
+func print_fromlog(w io.Writer, resp *fromlog_response) bool {
+	if len(resp.Missing) == 0 {
+		fmt.Fprintf(w, "no missing headers, pkg-config modules or programs in the log\n")
+		return true
+	}
+	longest_kind := 0
+	longest_name := 0
+	for _, m := range resp.Missing {
+		if len(m.Kind) > longest_kind {
+			longest_kind = len(m.Kind)
+		}
+		if len(m.Name) > longest_name {
+			longest_name = len(m.Name)
+		}
+	}
+	ok := true
+	sp := strings.Repeat(" ", longest_kind+longest_name+2)
+	for _, m := range resp.Missing {
+		fmt.Fprintf(w, "%s%s%s%s",
+			m.Kind,
+			sp[0:(longest_kind-len(m.Kind)+2)],
+			m.Name,
+			sp[0:(longest_name-len(m.Name)+2)])
+		if len(m.Matches) == 0 && m.Skipped {
+			fmt.Fprintf(w, "SKIPPED (too many headers to search for)\n")
+			ok = false
+			continue
+		}
+		if len(m.Matches) == 0 {
+			fmt.Fprintf(w, "NOT FOUND\n")
+			ok = false
+			continue
+		}
+		matches := make([]string, len(m.Matches))
+		for i, match := range m.Matches {
+			matches[i] = fmt.Sprintf("%s (%s)", match.Pkgver, match.Path)
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(matches, ", "))
+	}
+	if len(resp.Makedepends) != 0 || len(resp.Hostmakedepends) != 0 {
+		fmt.Fprintf(w, "\n")
+	}
+	if len(resp.Hostmakedepends) != 0 {
+		fmt.Fprintf(w, "hostmakedepends=\"%s\"\n", strings.Join(resp.Hostmakedepends, " "))
+	}
+	if len(resp.Makedepends) != 0 {
+		fmt.Fprintf(w, "makedepends=\"%s\"\n", strings.Join(resp.Makedepends, " "))
+	}
+	return ok
+}
+
+/*
+ * POST /-/fromlog with an xbps-src build log as the body (or as "log" or
+ * "file" in a form)
+ * GET shows a form for pasting one
+ */
+func serve_fromlog(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	h := w.Header()
+	if req.Method != "POST" {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:fromlog</title>`)
+		fmt.Fprintf(w, `<form action="/-/fromlog" method="post" enctype="multipart/form-data">`)
+		fmt.Fprintf(w, `<textarea name="log" rows="25" cols="100" placeholder="build log"></textarea><br>`)
+		fmt.Fprintf(w, `or <input type="file" name="file"> <input type="submit" value="what's missing?">`)
+		fmt.Fprintf(w, `</form>`)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, fromlog_max_size)
+	var body io.Reader = req.Body
+	ctype := req.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(ctype, "multipart/form-data"):
+		if err := req.ParseMultipartForm(fromlog_max_size); err != nil {
+			serve_error(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+		if log := req.FormValue("log"); log != "" {
+			body = strings.NewReader(log)
+		} else if f, _, err := req.FormFile("file"); err == nil {
+			defer f.Close()
+			body = f
+		} else {
+			serve_error(w, req, http.StatusBadRequest, "no log in the form")
+			return
+		}
+	case strings.HasPrefix(ctype, "application/x-www-form-urlencoded"):
+		// what "curl --data-binary @log" sends too, so it's only a form
+		// if it has the field
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			serve_error(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+		body = bytes.NewReader(data)
+		if form, err := url.ParseQuery(string(data)); err == nil && form.Get("log") != "" {
+			body = strings.NewReader(form.Get("log"))
+		}
+	}
+	db := xd.Get()
+	missing, err := db.FromLog(body)
+	if err != nil {
+		serve_error(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp := make_fromlog_response(missing)
+	h.Set("Last-Modified", db.LastModified)
+
+	if request_format(req) == "json" {
+		h.Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(resp)
+		return
+	}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	print_fromlog(w, resp)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"html"
+	"io"
 	"log"
 	"net/http"
 	// _ "net/http/pprof"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 )
@@ -36,8 +38,34 @@ func splitPath(path string) []string {
 	return names
 }
 
-func print_header(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs, abspath string) {
-	fmt.Fprintf(w, `<a href="/">/</a>`)
+/*
+ * "1 file", "2 files"
+ */
+func count_str(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+/*
+ * "?pkg=<pkgname>" to keep the package filter in links, empty if there isn't one
+ */
+func pkg_query(pkgver xldb.Pkgver) string {
+	if pkgver == "" {
+		return ""
+	}
+	return "?pkg=" + url.QueryEscape(pkgver.Name())
+}
+
+func print_header(w io.Writer, db *xldb.Gen, vfs xldb.Vfs, base string, abspath string, pkgver xldb.Pkgver, is_html bool) {
+	base_h := html.EscapeString(base)
+	query_h := html.EscapeString(pkg_query(pkgver))
+	if is_html {
+		fmt.Fprintf(w, `<a href="%s/%s">/</a>`, base_h, query_h)
+	} else {
+		fmt.Fprintf(w, "/")
+	}
 	pathLen := len(abspath) + len(" is a ")
 	components := splitPath(abspath)
 	p := ""
@@ -47,20 +75,53 @@ func print_header(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs, abspath s
 		if i == len(components)-1 {
 			dirslash_url = ""
 			dirslash_dis = ""
-			if xd.VfsIsDir(vfs, 3) {
+			if db.VfsIsDir(vfs, 3) {
 				dirslash_url = "/"
 				dirslash_dis = "/"
 				pathLen += 1
 			}
 		}
 		part_uh := html.EscapeString(url.PathEscape(name)) + dirslash_url
-		fmt.Fprintf(w, `<a href="/%s%s">%s%s</a>`,
-			p, part_uh,
-			html.EscapeString(name), dirslash_dis)
+		if is_html {
+			fmt.Fprintf(w, `<a href="%s/%s%s%s">%s%s</a>`,
+				base_h, p, part_uh, query_h,
+				html.EscapeString(name), dirslash_dis)
+		} else {
+			fmt.Fprintf(w, "%s%s", name, dirslash_dis)
+		}
 		p += part_uh
 	}
 	fmt.Fprintf(w, " is a ")
 
+	if pkgver != "" {
+		vtype := db.VfsGetPkgType(vfs, pkgver)
+		typename := "link"
+		if vtype.IsDir() {
+			typename = "dir"
+		} else if vtype.IsFile() {
+			typename = "file"
+		}
+		pkgver_s := string(pkgver)
+		if is_html {
+			pkgver_s = fmt.Sprintf(`<a href="%s/-/pkg/%s">%s</a>`,
+				base_h,
+				html.EscapeString(url.PathEscape(pkgver.Name())),
+				html.EscapeString(string(pkgver)))
+		}
+		fmt.Fprintf(w, "%s in %s\n", typename, pkgver_s)
+		if vtype.IsDir() {
+			// not counting itself
+			total := db.VfsCountPkgTypes(vfs, pkgver)
+			fmt.Fprintf(w, "%s(%s, %s and %s below it)\n",
+				strings.Repeat(" ", pathLen),
+				count_str(total.Dir-1, "dir"),
+				count_str(total.File, "file"),
+				count_str(total.Link, "link"))
+		}
+		fmt.Fprintf(w, "\n")
+		return
+	}
+
 	spaces := ""
 	dotype := func(n int, u string) {
 		if n == 0 {
@@ -79,7 +140,7 @@ func print_header(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs, abspath s
 			spaces = strings.Repeat(" ", pathLen)
 		}
 	}
-	types := xd.VfsGetTypes(vfs)
+	types := db.VfsGetTypes(vfs)
 	dotype(types.Dir, "dir")
 	dotype(types.File, "file")
 	dotype(types.Link, "link")
@@ -110,16 +171,46 @@ type child_entry struct {
 	vlen     int
 }
 
-func print_children(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs) {
-	entries := make([]child_entry, len(*vfs))
+/*
+ * list the children of vfs, only the ones pkgver owns if it isn't empty
+ * returns false if there weren't any
+ */
+func print_children(w io.Writer, db *xldb.Gen, vfs xldb.Vfs, pkgver xldb.Pkgver, is_html bool) bool {
+	children := db.VfsGetChildren(vfs)
+	entries := make([]child_entry, 0, len(children))
 	longest_vlen := 0
-	i := 0
-	for name, cvfs := range *vfs {
-		entry := &entries[i]
-		types := xd.VfsGetTypes(cvfs)
+	for name, cvfs := range children {
+		var types xldb.VfsTypes
+		is_dir := false
+		if pkgver != "" {
+			vtype := db.VfsGetPkgType(cvfs, pkgver)
+			switch {
+			case !vtype.Ok():
+				continue
+			case vtype.IsDir():
+				types.Dir = 1
+				is_dir = true
+			case vtype.IsFile():
+				types.File = 1
+			default:
+				types.Link = 1
+				// only follow this package's link, not other owners'
+				tgt := db.VfsLinkResolveTarget(cvfs, vtype.GetTarget())
+				is_dir = tgt != xldb.VFS_NONE && db.VfsIsDir(tgt, 2)
+			}
+		} else {
+			types = db.VfsGetTypes(cvfs)
+			is_dir = types.Dir > 0 || (types.Link > 0 && db.VfsIsDir(cvfs, 3))
+		}
+		entries = append(entries, child_entry{})
+		entry := &entries[len(entries)-1]
 		entry.name = name
 		entry.typestr = make_typestr(types)
-		entry.is_dir = types.Dir > 0 || (types.Link > 0 && xd.VfsIsDir(cvfs, 3))
+		if pkgver != "" {
+			// the counts would all be 1
+			entry.typestr = strings.TrimSuffix(entry.typestr, " (1)")
+		}
+		entry.is_dir = is_dir
 		entry.name_uh = html.EscapeString(url.PathEscape(name))
 		entry.name_h = html.EscapeString(name)
 		entry.vlen = len(name)
@@ -130,7 +221,6 @@ func print_children(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs) {
 		if entry.vlen > longest_vlen {
 			longest_vlen = entry.vlen
 		}
-		i += 1
 	}
 	sort.Slice(entries, func(i1, i2 int) bool {
 		e1, e2 := entries[i1], entries[i2]
@@ -140,17 +230,28 @@ func print_children(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs) {
 			return e1.is_dir
 		}
 	})
+	query_h := html.EscapeString(pkg_query(pkgver))
 	sp := strings.Repeat(" ", longest_vlen+2)
 	for _, entry := range entries {
-		fmt.Fprintf(w, `<a href="./%s%s">%s%s</a>%s%s%s`,
+		if !is_html {
+			fmt.Fprintf(w, "%s%s%s%s\n",
+				entry.name,
+				entry.dirslash,
+				sp[0:(longest_vlen-entry.vlen+2)],
+				entry.typestr)
+			continue
+		}
+		fmt.Fprintf(w, `<a href="./%s%s%s">%s%s</a>%s%s%s`,
 			entry.name_uh,
 			entry.dirslash,
+			query_h,
 			entry.name_h,
 			entry.dirslash,
 			sp[0:(longest_vlen-entry.vlen+2)],
 			entry.typestr,
 			"\n")
 	}
+	return len(entries) != 0
 }
 
 type owner_entry struct {
@@ -158,13 +259,20 @@ type owner_entry struct {
 	typestr string
 }
 
-func print_owner_info(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs, real_path string) {
-	owners := make([]owner_entry, len(xd.VfsGetOwners(vfs)))
+/*
+ * list the owners of vfs, only pkg_filter if it isn't empty
+ */
+func print_owner_info(w io.Writer, db *xldb.Gen, vfs xldb.Vfs, base string, real_path string, pkg_filter xldb.Pkgver, is_html bool) {
+	owners := make([]owner_entry, 0, len(db.VfsGetOwners(vfs)))
 	is_file := false
 	longest_owner := 0
-	i := 0
-	for pkgver, vtype := range xd.VfsGetOwners(vfs) {
-		owner := &owners[i]
+	for _, vo := range db.VfsGetOwners(vfs) {
+		pkgver, vtype := vo.Pkgver, vo.Type
+		if pkg_filter != "" && pkgver != pkg_filter {
+			continue
+		}
+		owners = append(owners, owner_entry{})
+		owner := &owners[len(owners)-1]
 		owner.pkgver = pkgver
 		switch vtype {
 		case xldb.XLDB_DIR:
@@ -173,9 +281,15 @@ func print_owner_info(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs, real_
 			owner.typestr = "file"
 			is_file = true
 		default:
-			if tgt := xd.VfsLinkResolveTarget(vfs, vtype.GetTarget()); tgt != nil {
-				urlpath := xd.VfsGetPathUrlencoded(tgt)
-				urlpath += xd.VfsGetDirslash(tgt, 3)
+			if !is_html {
+				owner.typestr = "link to " + vtype.GetTarget()
+			} else if tgt := db.VfsLinkResolveTarget(vfs, vtype.GetTarget()); tgt != xldb.VFS_NONE {
+				urlpath := base + db.VfsGetPathUrlencoded(tgt)
+				urlpath += db.VfsGetDirslash(tgt, 3)
+				// keep the filter if the package has the target too
+				if db.VfsGetPkgType(tgt, pkg_filter).Ok() {
+					urlpath += pkg_query(pkg_filter)
+				}
 				owner.typestr = fmt.Sprintf(`link to <a href="%s">%s</a>`,
 					html.EscapeString(urlpath),
 					html.EscapeString(vtype.GetTarget()))
@@ -187,13 +301,15 @@ func print_owner_info(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs, real_
 		if len(pkgver) > longest_owner {
 			longest_owner = len(pkgver)
 		}
-		i += 1
 	}
 	sort.Slice(owners, func(i1, i2 int) bool {
 		return owners[i1].pkgver < owners[i2].pkgver
 	})
 	if is_file {
-		path := html.EscapeString(shellquote(real_path))
+		path := shellquote(real_path)
+		if is_html {
+			path = html.EscapeString(path)
+		}
 		for _, entry := range owners {
 			if entry.typestr != "file" {
 				continue
@@ -210,22 +326,264 @@ func print_owner_info(w http.ResponseWriter, xd *xldb.Xldb, vfs *xldb.Vfs, real_
 		if i < len(owners)-1 {
 			newline = "\n"
 		}
+		pkgver_s := string(entry.pkgver)
+		if is_html && pkg_filter == "" {
+			// show only this package's files
+			pkgver_s = fmt.Sprintf(`<a href="%s">%s</a>`,
+				html.EscapeString(pkg_query(entry.pkgver)),
+				html.EscapeString(string(entry.pkgver)))
+		}
 		fmt.Fprintf(w, "%s%s%s%s",
-			entry.pkgver,
+			pkgver_s,
 			sp[0:(longest_owner-len(entry.pkgver)+2)],
 			entry.typestr,
 			newline)
 	}
 }
 
+func serve_error(w http.ResponseWriter, req *http.Request, status int, msg string) {
+	if wants_text(req) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		if req.Method != "HEAD" {
+			fmt.Fprintf(w, "%s\n", msg)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if req.Method != "HEAD" {
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:error</title>`)
+		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+		fmt.Fprintf(w, `%s`, html.EscapeString(msg))
+		fmt.Fprintf(w, `</pre>`)
+	}
+}
+
+/*
+ * whether to answer with plain text instead of html: asked for with
+ * ?format=txt or an accept header that has text/plain but not text/html,
+ * or it looks like curl or wget
+ */
+func wants_text(req *http.Request) bool {
+	if request_format(req) == "txt" {
+		return true
+	}
+	accept := req.Header.Get("Accept")
+	if strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/html") {
+		return true
+	}
+	ua := strings.ToLower(req.Header.Get("User-Agent"))
+	return strings.HasPrefix(ua, "curl/") || strings.HasPrefix(ua, "wget/")
+}
+
+/*
+ * show path in db
+ * base is prepended to absolute links (for browsing old commits)
+ */
+func serve_browse(w http.ResponseWriter, req *http.Request, db *xldb.Gen, base string, path string) {
+	h := w.Header()
+	h.Set("Vary", "Accept, User-Agent")
+	if !check_last_modified(w, req, db.LastModified) {
+		return
+	}
+
+	vfs := db.VfsDirFollowPath(xldb.VFS_ROOT, path)
+	if vfs == xldb.VFS_NONE {
+		serve_error(w, req, http.StatusNotFound, "not found")
+		return
+	}
+
+	// ?pkg=<pkgname> shows only what that package has
+	var pkgver xldb.Pkgver
+	if pkgname := req.URL.Query().Get("pkg"); pkgname != "" {
+		if pkgver = db.GetPkgver(pkgname); pkgver == "" {
+			serve_error(w, req, http.StatusNotFound, fmt.Sprintf("no package named '%s'", pkgname))
+			return
+		}
+		if !db.VfsGetPkgType(vfs, pkgver).Ok() {
+			serve_error(w, req, http.StatusNotFound, fmt.Sprintf("%s doesn't have %s", pkgver, db.VfsGetPath(vfs)))
+			return
+		}
+	}
+
+	// keep ?format= and the like when redirecting
+	query := ""
+	if req.URL.RawQuery != "" {
+		query = "?" + req.URL.RawQuery
+	}
+	cwd_is_dir := db.VfsIsDir(vfs, 3)
+	url_is_dir := strings.HasSuffix(req.URL.Path, "/")
+	if cwd_is_dir && !url_is_dir {
+		h.Add("Location", req.URL.Path+"/"+query)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	} else if url_is_dir && !cwd_is_dir {
+		h.Add("Location", strings.TrimRight(req.URL.Path, "/")+query)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	is_html := !wants_text(req)
+	if is_html {
+		h.Add("Content-Type", "text/html; charset=utf-8")
+	} else {
+		h.Add("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	if req.Method == "HEAD" {
+		return
+	}
+
+	dirslash := ""
+	if cwd_is_dir && db.VfsGetParent(vfs) != vfs {
+		dirslash = "/"
+	}
+
+	real_path := db.VfsGetPath(vfs)
+
+	if is_html {
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:%s%s%s</title>`,
+			html.EscapeString(strings.TrimPrefix(base, "/")),
+			html.EscapeString(real_path),
+			dirslash)
+		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+	}
+
+	if base != "" {
+		fmt.Fprintf(w, "commit %s from %s\n\n",
+			db.Commit,
+			db.LastModified)
+	}
+
+	print_header(w, db, vfs, base, real_path, pkgver, is_html)
+
+	if print_children(w, db, vfs, pkgver, is_html) {
+		fmt.Fprintf(w, "\n")
+	}
+
+	print_owner_info(w, db, vfs, base, real_path, pkgver, is_html)
+
+	if is_html {
+		fmt.Fprintf(w, `</pre>`)
+	} else {
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+/*
+ * add the last-modified header
+ * returns false if it answered with 304 and there's nothing else to do
+ */
+func check_last_modified(w http.ResponseWriter, req *http.Request, last_modified string) bool {
+	if last_modified == "" {
+		return true
+	}
+	w.Header().Set("Last-Modified", last_modified)
+	if req.Header.Get("If-Modified-Since") == last_modified {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+	return true
+}
+
+/*
+ * the output format asked for with ?format=, "html" by default
+ */
+func request_format(req *http.Request) string {
+	switch format := req.URL.Query().Get("format"); format {
+	case "txt", "json":
+		return format
+	default:
+		return "html"
+	}
+}
+
+/*
+ * only allow GET and HEAD, and add the headers every response should have
+ */
+func method_ok(w http.ResponseWriter, req *http.Request) bool {
+	w.Header().Set("Server", progname)
+	switch req.Method {
+	case "GET":
+		// ok
+	case "HEAD":
+		// ok
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+/*
+ * like method_ok but also allows POST
+ */
+func method_ok_post(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method == "POST" {
+		w.Header().Set("Server", progname)
+		return true
+	}
+	return method_ok(w, req)
+}
+
 func main() {
 	xd := xldb.Xldb{}
 	xd.Init()
+
+	if s := os.Getenv("VOIDFS_HISTORY"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatalf("voidfs: bad VOIDFS_HISTORY: %s", err)
+		}
+		xd.HistorySize = n
+	}
+	if s := os.Getenv("VOIDFS_CHANGES"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatalf("voidfs: bad VOIDFS_CHANGES: %s", err)
+		}
+		if n < 0 {
+			log.Fatalf("voidfs: bad VOIDFS_CHANGES: %d is negative", n)
+		}
+		xd.ChangesSize = n
+	}
+
+	if s := os.Getenv("VOIDFS_INDEX"); s != "" {
+		on, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("voidfs: bad VOIDFS_INDEX: %s", err)
+		}
+		xd.PathIndex = on
+	}
+
+	// file list to load instead of the git repo ("-" for stdin)
+	list := os.Getenv("VOIDFS_LIST")
+	load := func() (*xldb.ChangeSet, error) {
+		switch list {
+		case "":
+			return xd.Load()
+		case "-":
+			if xd.Get().LastModified != "" {
+				return nil, fmt.Errorf("voidfs: can't reload from stdin")
+			}
+			fallthrough
+		default:
+			return xd.LoadFile(list)
+		}
+	}
+
+	if len(os.Args) > 1 {
+		os.Exit(run_cli(&xd, load, os.Args[1:]))
+	}
+
 	go func() {
-		sig := make(chan os.Signal)
+		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, syscall.SIGHUP, syscall.SIGUSR1)
 
-		if err := xd.Load(); err != nil {
+		if _, err := load(); err != nil {
 			log.Fatal(err)
 		}
 		fmt.Println("voidfs: initial load done")
@@ -235,96 +593,166 @@ func main() {
 			case syscall.SIGHUP:
 				fmt.Println("voidfs: received SIGHUP, reloading database")
 				go func() {
-					if err := xd.Load(); err != nil {
+					if _, err := load(); err != nil {
 						fmt.Fprintf(os.Stderr, "%s\n", err)
 					}
 					fmt.Println("voidfs: reload done")
 				}()
 			case syscall.SIGUSR1:
-				xd.RLock()
-				xd.Vfsck(nil)
-				xd.RUnlock()
+				xd.Get().Vfsck(xldb.VFS_NONE)
 			}
 		}
 	}()
-	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-
-		switch req.Method {
-		case "GET":
-			// ok
-		case "HEAD":
-			// ok
-		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
+	http.HandleFunc("/-/ready", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
 			return
 		}
-
-		xd.RLock()
-		defer xd.RUnlock()
-
-		h := w.Header()
-		h.Add("Content-Type", "text/html; charset=utf-8")
-		h.Add("Server", progname)
-		if xd.LastModified != "" {
-			h.Add("Last-Modified", xd.LastModified)
-			if req.Header.Get("If-Modified-Since") == xd.LastModified {
-				w.WriteHeader(http.StatusNotModified)
-				return
-			}
+		serve_ready(w, req, &xd)
+	})
+	http.HandleFunc("/-/diff/", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
+			return
 		}
-
-		vfs := xd.VfsDirFollowPath(nil, req.URL.Path)
-		if vfs == nil {
-			w.WriteHeader(http.StatusNotFound)
-			if req.Method != "HEAD" {
-				fmt.Fprintf(w, `<!doctype html>`)
-				fmt.Fprintf(w, `<title>voidfs:error</title>`)
-				fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
-				fmt.Fprintf(w, `not found`)
-				fmt.Fprintf(w, `</pre>`)
-			}
+		if serve_not_ready(w, req, &xd) {
 			return
 		}
-
-		cwd_is_dir := xd.VfsIsDir(vfs, 3)
-		url_is_dir := strings.HasSuffix(req.URL.Path, "/")
-		if cwd_is_dir && !url_is_dir {
-			h.Add("Location", req.URL.Path+"/")
-			w.WriteHeader(http.StatusMovedPermanently)
+		serve_diff(w, req, &xd)
+	})
+	http.HandleFunc("/-/changes", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
 			return
-		} else if url_is_dir && !cwd_is_dir {
-			h.Add("Location", strings.TrimRight(req.URL.Path, "/"))
-			w.WriteHeader(http.StatusMovedPermanently)
+		}
+		if serve_not_ready(w, req, &xd) {
 			return
 		}
-
-		if req.Method == "HEAD" {
+		serve_changes(w, req, &xd)
+	})
+	http.HandleFunc("/-/feed.atom", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
 			return
 		}
-
-		dirslash := ""
-		if cwd_is_dir && xd.VfsGetParent(vfs) != vfs {
-			dirslash = "/"
+		if serve_not_ready(w, req, &xd) {
+			return
 		}
+		serve_feed(w, req, &xd)
+	})
+	http.HandleFunc("/-/cnf/", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_cnf(w, req, &xd)
+	})
+	http.HandleFunc("/-/soname/", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_soname(w, req, &xd)
+	})
+	http.HandleFunc("/-/needs", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok_post(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_needs(w, req, &xd)
+	})
+	http.HandleFunc("/-/fromlog", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok_post(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_fromlog(w, req, &xd)
+	})
+	http.HandleFunc("/-/owners", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok_post(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_owners(w, req, &xd)
+	})
+	http.HandleFunc("/-/api/v1/", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_api(w, req, &xd)
+	})
+	http.HandleFunc("/-/pkg/", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_pkg(w, req, xd.Get(), "", strings.TrimPrefix(req.URL.Path, "/-/pkg/"))
+	})
+	http.HandleFunc("/-/pkgs/", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_pkgs(w, req, &xd)
+	})
+	http.HandleFunc("/-/search", func(w http.ResponseWriter, req *http.Request) {
+		if !method_ok(w, req) {
+			return
+		}
+		if serve_not_ready(w, req, &xd) {
+			return
+		}
+		serve_search(w, req, &xd)
+	})
+	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 
-		real_path := xd.VfsGetPath(vfs)
-
-		fmt.Fprintf(w, `<!doctype html>`)
-		fmt.Fprintf(w, `<title>voidfs:%s%s</title>`,
-			html.EscapeString(real_path),
-			dirslash)
-		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
-
-		print_header(w, &xd, vfs, real_path)
+		if !method_ok(w, req) {
+			return
+		}
 
-		if len(*vfs) != 0 {
-			print_children(w, &xd, vfs)
-			fmt.Fprintf(w, "\n")
+		if serve_not_ready(w, req, &xd) {
+			return
 		}
 
-		print_owner_info(w, &xd, vfs, real_path)
+		// "/@<commit-or-date>/path"
+		if strings.HasPrefix(req.URL.Path, "/@") {
+			rev := strings.TrimPrefix(req.URL.Path, "/@")
+			path := ""
+			if slash := strings.Index(rev, "/"); slash != -1 {
+				rev, path = rev[0:slash], rev[slash:]
+			}
+			db, err := xd.GetAt(rev)
+			if err == xldb.ErrHistoryBusy {
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", retry_after))
+				serve_error(w, req, http.StatusServiceUnavailable, err.Error())
+				return
+			} else if err != nil {
+				serve_error(w, req, http.StatusNotFound, err.Error())
+				return
+			}
+			base := "/@" + url.PathEscape(rev)
+			if strings.HasPrefix(path, "/-/pkg/") {
+				serve_pkg(w, req, db, base, strings.TrimPrefix(path, "/-/pkg/"))
+				return
+			}
+			serve_browse(w, req, db, base, path)
+			return
+		}
 
-		fmt.Fprintf(w, `</pre>`)
+		serve_browse(w, req, xd.Get(), "", req.URL.Path)
 	})
 	addr := os.Getenv("VOIDFS_ADDR")
 	if addr == "" {
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+import "xldb"
+
+// largest file POST /-/needs accepts
+const needs_max_size = 128 << 20
+
+/*
+ * print what needs() found
+ * returns false if something wasn't found
+ */
+func print_needs(w io.Writer, needs []xldb.Need) bool {
+	if len(needs) == 0 {
+		fmt.Fprintf(w, "no PT_INTERP or DT_NEEDED entries (statically linked?)\n")
+		return true
+	}
+	longest := 0
+	for _, need := range needs {
+		if len(need.Name) > longest {
+			longest = len(need.Name)
+		}
+	}
+	ok := true
+	pkgnames := make(map[string]bool)
+	sp := strings.Repeat(" ", longest+2)
+	for _, need := range needs {
+		kind := "needed"
+		if need.Interp {
+			kind = "interp"
+		}
+		fmt.Fprintf(w, "%s  %s%s", kind, need.Name, sp[0:(longest-len(need.Name)+2)])
+		if !need.Found {
+			fmt.Fprintf(w, "NOT FOUND\n")
+			ok = false
+			continue
+		}
+		owners := make([]string, len(need.Pkgvers))
+		for i, pkgver := range need.Pkgvers {
+			owners[i] = string(pkgver)
+			pkgnames[pkgver.Name()] = true
+		}
+		fmt.Fprintf(w, "%s (%s)\n", strings.Join(owners, ", "), need.Path)
+	}
+	if len(pkgnames) != 0 {
+		names := make([]string, 0, len(pkgnames))
+		for name := range pkgnames {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "\npackages: %s\n", strings.Join(names, " "))
+	}
+	return ok
+}
+
+/*
+ * POST /-/needs with an elf file as the body (or as "file" in a form)
+ * GET shows a form for uploading one
+ */
+func serve_needs(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	h := w.Header()
+	if req.Method != "POST" {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:needs</title>`)
+		fmt.Fprintf(w, `<form action="/-/needs" method="post" enctype="multipart/form-data">`)
+		fmt.Fprintf(w, `<input type="file" name="file"> <input type="submit" value="what does it need?">`)
+		fmt.Fprintf(w, `</form>`)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, needs_max_size)
+	var body io.Reader = req.Body
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := req.FormFile("file")
+		if err != nil {
+			serve_error(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		serve_error(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	db := xd.Get()
+	needs, err := db.Needs(bytes.NewReader(data))
+	if err != nil {
+		serve_error(w, req, http.StatusBadRequest, fmt.Sprintf("not an elf file: %s", err))
+		return
+	}
+	h.Set("Last-Modified", db.LastModified)
+
+	if request_format(req) == "json" {
+		h.Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(needs)
+		return
+	}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	print_needs(w, needs)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+import "xldb"
+
+// largest list of paths POST /-/owners accepts
+const owners_max_size = 8 << 20
+
+type owners_entry struct {
+	Path     string         `json:"path"`
+	Exists   bool           `json:"exists"`
+	Type     string         `json:"type,omitempty"` // "mixed" if the owners don't agree
+	Resolved string         `json:"resolved"`       // empty if it doesn't exist or the link is dangling
+	Owners   []search_owner `json:"owners"`
+	Error    string         `json:"error,omitempty"`
+}
+
+/*
+ * look up one path, links to dirs along the way are followed but the
+ * owners and type are of the path itself
+ */
+func lookup_owners(db *xldb.Gen, path string) owners_entry {
+	entry := owners_entry{Path: path, Owners: make([]search_owner, 0)}
+	if !strings.HasPrefix(path, "/") {
+		entry.Error = "not an absolute path"
+		return entry
+	}
+	vfs := db.VfsLookupPath(path)
+	if vfs == xldb.VFS_NONE {
+		return entry
+	}
+	entry.Exists = true
+	entry.Owners = search_owners(db, vfs)
+	for _, owner := range entry.Owners {
+		if entry.Type == "" {
+			entry.Type = owner.Type
+		} else if entry.Type != owner.Type {
+			entry.Type = "mixed"
+		}
+	}
+	if rvfs := db.VfsResolvePath(path); rvfs != xldb.VFS_NONE {
+		entry.Resolved = db.VfsGetPath(rvfs)
+	}
+	return entry
+}
+
+func print_owners_tsv(w io.Writer, entries []owners_entry) {
+	fmt.Fprintf(w, "path\texists\ttype\tresolved\towners\n")
+	for _, entry := range entries {
+		owners := make([]string, len(entry.Owners))
+		for i, owner := range entry.Owners {
+			owners[i] = string(owner.Pkgver)
+		}
+		typestr := entry.Type
+		if entry.Error != "" {
+			typestr = "error: " + entry.Error
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\n",
+			entry.Path,
+			entry.Exists,
+			typestr,
+			entry.Resolved,
+			strings.Join(owners, ","))
+	}
+}
+
+/*
+ * POST /-/owners with one absolute path per line
+ * every path is looked up in the same generation
+ * GET shows a form for pasting them
+ */
+func serve_owners(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	h := w.Header()
+	if req.Method != "POST" {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:owners</title>`)
+		fmt.Fprintf(w, `<form action="/-/owners" method="post" enctype="multipart/form-data">`)
+		fmt.Fprintf(w, `<textarea name="paths" rows="25" cols="100" placeholder="one path per line"></textarea><br>`)
+		fmt.Fprintf(w, `<input type="submit" value="who owns these?">`)
+		fmt.Fprintf(w, `</form>`)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, owners_max_size)
+	var body io.Reader = req.Body
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := req.ParseMultipartForm(owners_max_size); err != nil {
+			serve_error(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+		body = strings.NewReader(req.FormValue("paths"))
+	}
+
+	db := xd.Get()
+	entries := make([]owners_entry, 0)
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" {
+			continue
+		}
+		entries = append(entries, lookup_owners(db, path))
+	}
+	if err := scanner.Err(); err != nil {
+		serve_error(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.Set("Last-Modified", db.LastModified)
+
+	if request_format(req) == "json" {
+		h.Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(entries)
+		return
+	}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	print_owners_tsv(w, entries)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+)
+
+import "xldb"
+
+type pkg_entry struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Target string `json:"target,omitempty"`
+	vfs    xldb.Vfs
+}
+
+type pkg_response struct {
+	Pkgname string      `json:"pkgname"`
+	Version string      `json:"version"`
+	Pkgver  xldb.Pkgver `json:"pkgver"`
+	Types   api_types   `json:"types"`
+	Entries []pkg_entry `json:"entries"`
+}
+
+func make_pkg_response(db *xldb.Gen, pkgver xldb.Pkgver) *pkg_response {
+	pkgname, version := pkgver.Split()
+	resp := &pkg_response{
+		Pkgname: pkgname,
+		Version: version,
+		Pkgver:  pkgver,
+		Entries: make([]pkg_entry, 0),
+	}
+	for _, e := range db.PkgEntries(pkgver) {
+		entry := pkg_entry{Path: e.Path, vfs: e.Vfs}
+		switch {
+		case e.Type.IsDir():
+			entry.Type = "dir"
+			resp.Types.Dir += 1
+		case e.Type.IsFile():
+			entry.Type = "file"
+			resp.Types.File += 1
+		default:
+			entry.Type = "link"
+			entry.Target = e.Type.GetTarget()
+			resp.Types.Link += 1
+		}
+		resp.Entries = append(resp.Entries, entry)
+	}
+	return resp
+}
+
+func print_pkg(w io.Writer, db *xldb.Gen, base string, resp *pkg_response, is_html bool) {
+	base_h := html.EscapeString(base)
+	fmt.Fprintf(w, "%s: %s, %s, %s\n\n",
+		resp.Pkgver,
+		count_str(resp.Types.Dir, "dir"),
+		count_str(resp.Types.File, "file"),
+		count_str(resp.Types.Link, "link"))
+
+	longest_vlen := 0
+	for _, entry := range resp.Entries {
+		vlen := len(entry.Path)
+		if entry.Type == "dir" {
+			vlen += 1
+		}
+		if vlen > longest_vlen {
+			longest_vlen = vlen
+		}
+	}
+	sp := strings.Repeat(" ", longest_vlen+2)
+	for _, entry := range resp.Entries {
+		dirslash := ""
+		if entry.Type == "dir" {
+			dirslash = "/"
+		}
+		pad := sp[0:(longest_vlen - len(entry.Path) - len(dirslash) + 2)]
+		if !is_html {
+			typestr := entry.Type
+			if entry.Type == "link" {
+				typestr = "link to " + entry.Target
+			}
+			fmt.Fprintf(w, "%s%s%s%s\n", entry.Path, dirslash, pad, typestr)
+			continue
+		}
+		typestr := entry.Type
+		if entry.Type == "link" {
+			if tgt := db.VfsLinkResolveTarget(entry.vfs, entry.Target); tgt != xldb.VFS_NONE {
+				typestr = fmt.Sprintf(`link to <a href="%s%s%s">%s</a>`,
+					base_h,
+					html.EscapeString(db.VfsGetPathUrlencoded(tgt)),
+					db.VfsGetDirslash(tgt, 3),
+					html.EscapeString(entry.Target))
+			} else {
+				typestr = fmt.Sprintf(`link to <span>%s</span>`,
+					html.EscapeString(entry.Target))
+			}
+		}
+		fmt.Fprintf(w, `<a href="%s%s%s">%s%s</a>%s%s`+"\n",
+			base_h,
+			html.EscapeString(db.VfsGetPathUrlencoded(entry.vfs)),
+			dirslash,
+			html.EscapeString(entry.Path),
+			dirslash,
+			pad,
+			typestr)
+	}
+}
+
+/*
+ * [/@<rev>]/-/pkg/<pkgname>[.txt|.json]: everything a package owns
+ */
+func serve_pkg(w http.ResponseWriter, req *http.Request, db *xldb.Gen, base string, pkgname string) {
+	if !check_last_modified(w, req, db.LastModified) {
+		return
+	}
+	format := request_format(req)
+	if format == "html" && wants_text(req) {
+		format = "txt"
+	}
+	// pkgnames can have dots in them so only take the extension off if
+	// that leaves a package
+	for _, ext := range []string{"txt", "json"} {
+		if name := strings.TrimSuffix(pkgname, "."+ext); name != pkgname &&
+			db.GetPkgver(pkgname) == "" && db.GetPkgver(name) != "" {
+			pkgname = name
+			format = ext
+		}
+	}
+	pkgver := db.GetPkgver(pkgname)
+	if pkgver == "" {
+		serve_error(w, req, http.StatusNotFound, fmt.Sprintf("no package named '%s'", pkgname))
+		return
+	}
+	resp := make_pkg_response(db, pkgver)
+
+	h := w.Header()
+	switch format {
+	case "json":
+		h.Set("Content-Type", "application/json")
+		if req.Method == "HEAD" {
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(resp)
+	case "txt":
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		print_pkg(w, db, base, resp, false)
+	default:
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:pkg:%s</title>`, html.EscapeString(pkgname))
+		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+		print_pkg(w, db, base, resp, true)
+		fmt.Fprintf(w, `</pre>`)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+import "xldb"
+
+type pkgs_entry struct {
+	Pkgname string      `json:"pkgname"`
+	Version string      `json:"version"`
+	Pkgver  xldb.Pkgver `json:"pkgver"`
+	Types   api_types   `json:"types"`
+}
+
+/*
+ * the packages to show, filtered by ?prefix= and ?q= (substring) and
+ * sorted by ?sort=name (the default) or ?sort=files (most first)
+ */
+func list_pkgs(db *xldb.Gen, query url.Values) []pkgs_entry {
+	prefix := query.Get("prefix")
+	substr := query.Get("q")
+	rv := make([]pkgs_entry, 0)
+	for _, info := range db.Pkgs() {
+		if !strings.HasPrefix(info.Pkgname, prefix) || !strings.Contains(info.Pkgname, substr) {
+			continue
+		}
+		rv = append(rv, pkgs_entry{
+			Pkgname: info.Pkgname,
+			Version: info.Version,
+			Pkgver:  info.Pkgver,
+			Types:   make_api_types(info.Types),
+		})
+	}
+	if query.Get("sort") == "files" {
+		sort.SliceStable(rv, func(i1, i2 int) bool {
+			return rv[i1].Types.File > rv[i2].Types.File
+		})
+	}
+	return rv
+}
+
+func print_pkgs_form(w io.Writer, query url.Values) {
+	fmt.Fprintf(w, `<form action="/-/pkgs/">`)
+	fmt.Fprintf(w, `prefix <input name="prefix" size="20" value="%s"> `, html.EscapeString(query.Get("prefix")))
+	fmt.Fprintf(w, `containing <input name="q" size="20" value="%s"> `, html.EscapeString(query.Get("q")))
+	fmt.Fprintf(w, `<select name="sort">`)
+	for _, s := range []string{"name", "files"} {
+		selected := ""
+		if s == query.Get("sort") {
+			selected = " selected"
+		}
+		fmt.Fprintf(w, `<option%s>%s</option>`, selected, s)
+	}
+	fmt.Fprintf(w, `</select> <input type="submit" value="filter"></form>`)
+}
+
+func print_pkgs(w io.Writer, entries []pkgs_entry, is_html bool) {
+	es := "s"
+	if len(entries) == 1 {
+		es = ""
+	}
+	fmt.Fprintf(w, "%d package%s\n\n", len(entries), es)
+
+	longest_name := 0
+	longest_version := 0
+	for _, entry := range entries {
+		if len(entry.Pkgname) > longest_name {
+			longest_name = len(entry.Pkgname)
+		}
+		if len(entry.Version) > longest_version {
+			longest_version = len(entry.Version)
+		}
+	}
+	sp := strings.Repeat(" ", longest_name+longest_version+2)
+	for _, entry := range entries {
+		name := entry.Pkgname
+		if is_html {
+			name = fmt.Sprintf(`<a href="/-/pkg/%s">%s</a>`,
+				html.EscapeString(url.PathEscape(entry.Pkgname)),
+				html.EscapeString(entry.Pkgname))
+		}
+		fmt.Fprintf(w, "%s%s%s%s%6d dirs %6d files %6d links\n",
+			name,
+			sp[0:(longest_name-len(entry.Pkgname)+2)],
+			entry.Version,
+			sp[0:(longest_version-len(entry.Version)+2)],
+			entry.Types.Dir,
+			entry.Types.File,
+			entry.Types.Link)
+	}
+}
+
+/*
+ * /-/pkgs/: every package with its version and what it owns
+ */
+func serve_pkgs(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	db := xd.Get()
+	if !check_last_modified(w, req, db.LastModified) {
+		return
+	}
+	query := req.URL.Query()
+	entries := list_pkgs(db, query)
+
+	format := request_format(req)
+	if format == "html" && wants_text(req) {
+		format = "txt"
+	}
+	h := w.Header()
+	switch format {
+	case "json":
+		h.Set("Content-Type", "application/json")
+		if req.Method == "HEAD" {
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(entries)
+	case "txt":
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		print_pkgs(w, entries, false)
+	default:
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:pkgs</title>`)
+		print_pkgs_form(w, query)
+		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+		print_pkgs(w, entries, true)
+		fmt.Fprintf(w, `</pre>`)
+	}
+}
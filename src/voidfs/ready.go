@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+import "xldb"
+
+// seconds to tell clients to wait while the initial load is running
+const retry_after = 10
+
+func yesno(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func print_progress(w io.Writer, progress xldb.Progress) {
+	fmt.Fprintf(w, "ready:     %s\n", yesno(progress.Ready))
+	fmt.Fprintf(w, "loading:   %s\n", yesno(progress.Loading))
+	fmt.Fprintf(w, "lines:     %d\n", progress.Lines)
+	fmt.Fprintf(w, "packages:  %d\n", progress.Packages)
+	fmt.Fprintf(w, "elapsed:   %s\n", progress.Elapsed.Round(time.Millisecond))
+	if progress.Index != 0 {
+		fmt.Fprintf(w, "index:     %.1f MiB\n", float64(progress.Index)/(1<<20))
+	} else if progress.Ready {
+		fmt.Fprintf(w, "index:     off\n")
+	}
+}
+
+/*
+ * answer with 503 and the load progress if the initial load isn't done yet
+ * returns true if it did
+ */
+func serve_not_ready(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) bool {
+	progress := xd.GetProgress()
+	if progress.Ready {
+		return false
+	}
+	h := w.Header()
+	h.Set("Retry-After", fmt.Sprintf("%d", retry_after))
+	h.Set("Cache-Control", "no-store")
+	if wants_text(req) {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if req.Method != "HEAD" {
+			fmt.Fprintf(w, "the database is still loading, try again in a bit\n")
+		}
+		return true
+	}
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if req.Method == "HEAD" {
+		return true
+	}
+	fmt.Fprintf(w, `<!doctype html>`)
+	fmt.Fprintf(w, `<title>voidfs:loading</title>`)
+	fmt.Fprintf(w, `<meta http-equiv="refresh" content="%d">`, retry_after)
+	fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+	fmt.Fprintf(w, "the database is still loading, try again in a bit\n\n")
+	print_progress(w, progress)
+	fmt.Fprintf(w, `</pre>`)
+	return true
+}
+
+/*
+ * /-/ready: 200 once the initial load is done, 503 before that
+ */
+func serve_ready(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	progress := xd.GetProgress()
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	if !progress.Ready {
+		h.Set("Retry-After", fmt.Sprintf("%d", retry_after))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if req.Method == "HEAD" {
+		return
+	}
+	print_progress(w, progress)
+}
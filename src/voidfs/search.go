@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+import "xldb"
+
+// bounds for one search
+const search_limit = 1000
+const search_timeout = 2 * time.Second
+
+type search_owner struct {
+	Pkgver xldb.Pkgver `json:"pkgver"`
+	Type   string      `json:"type"`
+	Target string      `json:"target,omitempty"`
+}
+
+type search_match struct {
+	Path   string         `json:"path"`
+	Owners []search_owner `json:"owners"`
+	is_dir bool
+}
+
+type search_response struct {
+	Query     string          `json:"query"`
+	Mode      xldb.SearchMode `json:"mode"`
+	Truncated bool            `json:"truncated"`
+	TimedOut  bool            `json:"timed_out"`
+	ElapsedMs int64           `json:"elapsed_ms"`
+	Results   []search_match  `json:"results"`
+	elapsed   time.Duration
+}
+
+func search_owners(db *xldb.Gen, vfs xldb.Vfs) []search_owner {
+	owners := make([]search_owner, 0)
+	for _, vo := range db.VfsGetOwners(vfs) {
+		owner := search_owner{Pkgver: vo.Pkgver}
+		switch {
+		case vo.Type.IsDir():
+			owner.Type = "dir"
+		case vo.Type.IsFile():
+			owner.Type = "file"
+		default:
+			owner.Type = "link"
+			owner.Target = vo.Type.GetTarget()
+		}
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i1, i2 int) bool {
+		return owners[i1].Pkgver < owners[i2].Pkgver
+	})
+	return owners
+}
+
+/*
+ * guess the mode from the query if it wasn't given
+ */
+func search_mode(req *http.Request, q string) xldb.SearchMode {
+	if mode := req.URL.Query().Get("mode"); mode != "" {
+		return xldb.SearchMode(mode)
+	}
+	if strings.ContainsAny(q, "*?[") {
+		return xldb.SEARCH_GLOB
+	}
+	return xldb.SEARCH_NAME
+}
+
+func print_search_form(w io.Writer, q string, mode xldb.SearchMode) {
+	fmt.Fprintf(w, `<form action="/-/search">`)
+	fmt.Fprintf(w, `<input name="q" size="60" value="%s"> `, html.EscapeString(q))
+	fmt.Fprintf(w, `<select name="mode">`)
+	for _, m := range []xldb.SearchMode{xldb.SEARCH_NAME, xldb.SEARCH_SUBSTRING, xldb.SEARCH_GLOB, xldb.SEARCH_REGEX} {
+		selected := ""
+		if m == mode {
+			selected = " selected"
+		}
+		fmt.Fprintf(w, `<option%s>%s</option>`, selected, m)
+	}
+	fmt.Fprintf(w, `</select> <input type="submit" value="search"></form>`)
+}
+
+func print_search_results(w io.Writer, resp *search_response, is_html bool) {
+	fmt.Fprintf(w, "%d results in %s", len(resp.Results),
+		resp.elapsed.Round(time.Millisecond))
+	if resp.Truncated {
+		fmt.Fprintf(w, " (stopped at %d)", search_limit)
+	}
+	if resp.TimedOut {
+		fmt.Fprintf(w, " (timed out, results are incomplete)")
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	longest_path := 0
+	for _, match := range resp.Results {
+		vlen := len(match.Path)
+		if match.is_dir {
+			vlen += 1
+		}
+		if vlen > longest_path {
+			longest_path = vlen
+		}
+	}
+	sp := strings.Repeat(" ", longest_path+2)
+	for _, match := range resp.Results {
+		dirslash := ""
+		if match.is_dir {
+			dirslash = "/"
+		}
+		owners := make([]string, len(match.Owners))
+		for i, owner := range match.Owners {
+			typestr := owner.Type
+			if owner.Type == "link" {
+				typestr = "link to " + owner.Target
+			}
+			owners[i] = fmt.Sprintf("%s (%s)", owner.Pkgver, typestr)
+		}
+		ownerstr := strings.Join(owners, ", ")
+		pad := sp[0:(longest_path - len(match.Path) - len(dirslash) + 2)]
+		if is_html {
+			fmt.Fprintf(w, `<a href="%s%s">%s%s</a>%s%s`+"\n",
+				html.EscapeString((&url.URL{Path: match.Path}).EscapedPath()),
+				dirslash,
+				html.EscapeString(match.Path),
+				dirslash,
+				pad,
+				html.EscapeString(ownerstr))
+		} else {
+			fmt.Fprintf(w, "%s%s%s%s\n", match.Path, dirslash, pad, ownerstr)
+		}
+	}
+}
+
+/*
+ * /-/search?q=<pattern>&mode=name|substring|glob|regex
+ */
+func serve_search(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	db := xd.Get()
+	if !check_last_modified(w, req, db.LastModified) {
+		return
+	}
+	format := request_format(req)
+	q := req.URL.Query().Get("q")
+	mode := search_mode(req, q)
+
+	if q == "" && format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:search</title>`)
+		print_search_form(w, q, mode)
+		return
+	}
+
+	result, err := db.Search(mode, q, search_limit, search_timeout)
+	if err != nil {
+		serve_error(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp := &search_response{
+		Query:     q,
+		Mode:      mode,
+		Truncated: result.Truncated,
+		TimedOut:  result.TimedOut,
+		ElapsedMs: result.Elapsed.Milliseconds(),
+		elapsed:   result.Elapsed,
+		Results:   make([]search_match, 0, len(result.Matches)),
+	}
+	for _, m := range result.Matches {
+		resp.Results = append(resp.Results, search_match{
+			Path:   m.Path,
+			Owners: search_owners(db, m.Vfs),
+			is_dir: db.VfsIsDir(m.Vfs, 3),
+		})
+	}
+
+	h := w.Header()
+	switch format {
+	case "json":
+		h.Set("Content-Type", "application/json")
+		if req.Method == "HEAD" {
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(resp)
+	case "txt":
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		print_search_results(w, resp, false)
+	default:
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:search:%s</title>`, html.EscapeString(q))
+		print_search_form(w, q, mode)
+		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+		print_search_results(w, resp, true)
+		fmt.Fprintf(w, `</pre>`)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+import "xldb"
+
+type soname_provider struct {
+	Path     string      `json:"path"`
+	Pkgver   xldb.Pkgver `json:"pkgver"`
+	Type     string      `json:"type"`
+	Target   string      `json:"target,omitempty"`
+	Resolved string      `json:"resolved"`
+}
+
+func make_soname_providers(providers []xldb.SonameProvider) []soname_provider {
+	rv := make([]soname_provider, 0, len(providers))
+	for _, p := range providers {
+		sp := soname_provider{Path: p.Path, Pkgver: p.Pkgver, Type: "file", Resolved: p.Resolved}
+		if p.Type.IsLink() {
+			sp.Type = "link"
+			sp.Target = p.Type.GetTarget()
+		}
+		rv = append(rv, sp)
+	}
+	return rv
+}
+
+func print_soname(w io.Writer, name string, providers []soname_provider, is_html bool) {
+	esc := func(s string) string {
+		if is_html {
+			return html.EscapeString(s)
+		}
+		return s
+	}
+	link := func(path string) string {
+		if !is_html {
+			return path
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`,
+			html.EscapeString((&url.URL{Path: path}).EscapedPath()),
+			html.EscapeString(path))
+	}
+
+	longest_path, longest_pkgver := 0, 0
+	for _, p := range providers {
+		if len(p.Path) > longest_path {
+			longest_path = len(p.Path)
+		}
+		if len(p.Pkgver) > longest_pkgver {
+			longest_pkgver = len(p.Pkgver)
+		}
+	}
+	fmt.Fprintf(w, "%s\n", esc(name))
+	sp := strings.Repeat(" ", longest_path+longest_pkgver+2)
+	for _, p := range providers {
+		typestr := "file"
+		if p.Type == "link" {
+			typestr = "link to " + esc(p.Target)
+			if p.Resolved == "" {
+				typestr += " (dangling)"
+			} else {
+				typestr += " (" + link(p.Resolved) + ")"
+			}
+		}
+		fmt.Fprintf(w, "  %s%s%s%s%s\n",
+			link(p.Path),
+			sp[0:(longest_path-len(p.Path)+2)],
+			esc(string(p.Pkgver)),
+			sp[0:(longest_pkgver-len(p.Pkgver)+2)],
+			typestr)
+	}
+}
+
+/*
+ * /-/soname/<name>: what provides a library
+ * /-/soname/: sonames that more than one package has
+ */
+func serve_soname(w http.ResponseWriter, req *http.Request, xd *xldb.Xldb) {
+	db := xd.Get()
+	if !check_last_modified(w, req, db.LastModified) {
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/-/soname/")
+
+	sonames := make(map[string][]soname_provider)
+	title := "soname conflicts"
+	if name != "" {
+		providers := db.Soname(name)
+		if len(providers) == 0 {
+			serve_error(w, req, http.StatusNotFound, fmt.Sprintf("no package has %s", name))
+			return
+		}
+		sonames[name] = make_soname_providers(providers)
+		title = name
+	} else {
+		for name, providers := range db.SonameConflicts() {
+			sonames[name] = make_soname_providers(providers)
+		}
+	}
+	names := make([]string, 0, len(sonames))
+	for name := range sonames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := w.Header()
+	switch request_format(req) {
+	case "json":
+		h.Set("Content-Type", "application/json")
+		if req.Method == "HEAD" {
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		enc.Encode(sonames)
+	case "txt":
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		for _, name := range names {
+			print_soname(w, name, sonames[name], false)
+		}
+	default:
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if req.Method == "HEAD" {
+			return
+		}
+		fmt.Fprintf(w, `<!doctype html>`)
+		fmt.Fprintf(w, `<title>voidfs:%s</title>`, html.EscapeString(title))
+		fmt.Fprintf(w, `<pre style="cursor: default; margin: 0;">`)
+		if len(names) == 0 {
+			fmt.Fprintf(w, "no conflicts\n")
+		}
+		for _, name := range names {
+			print_soname(w, name, sonames[name], true)
+		}
+		fmt.Fprintf(w, `</pre>`)
+	}
+}
@@ -0,0 +1,439 @@
+/*
+ * what changed between two commits of the xlocate repo
+ */
+
+package xldb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+import "gitobj"
+
+type LinkChange struct {
+	Path      string `json:"path"`
+	OldTarget string `json:"old_target"` // empty if it was a file
+	NewTarget string `json:"new_target"` // empty if it's now a file
+}
+
+type PkgChange struct {
+	Name string `json:"name"`
+	Old  Pkgver `json:"old,omitempty"` // empty if added
+	New  Pkgver `json:"new,omitempty"` // empty if removed
+
+	// set for updated packages, and for added and removed ones in the change
+	// sets of reloads and RecentChanges() (but not Diff())
+	AddedPaths   []string     `json:"added_paths,omitempty"`
+	RemovedPaths []string     `json:"removed_paths,omitempty"`
+	ChangedLinks []LinkChange `json:"changed_links,omitempty"`
+}
+
+type ChangeSet struct {
+	Loaded *time.Time `json:"loaded,omitempty"` // when it was loaded, nil for Diff()
+
+	OldCommit       string `json:"old_commit"`
+	NewCommit       string `json:"new_commit"`
+	OldLastModified string `json:"old_last_modified"`
+	NewLastModified string `json:"new_last_modified"`
+
+	Added   []PkgChange `json:"added"`
+	Updated []PkgChange `json:"updated"`
+	Removed []PkgChange `json:"removed"`
+}
+
+func (self *ChangeSet) IsEmpty() bool {
+	return len(self.Added) == 0 && len(self.Updated) == 0 && len(self.Removed) == 0
+}
+
+/*
+ * the blob for each package in a tree of the repo
+ */
+type treePkg struct {
+	pkgver Pkgver
+	hash   gitobj.Hash
+}
+
+func readTreePkgs(repo *gitobj.Repo, tree gitobj.Hash) (map[string]treePkg, error) {
+	blobs, err := listTree(repo, tree, "")
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make(map[string]treePkg, len(blobs))
+	for _, blob := range blobs {
+		if !isValidPkgver(blob.Name) {
+			continue
+		}
+		pkgver := Pkgver(blob.Name)
+		pkgs[pkgver.Name()] = treePkg{pkgver, blob.Hash}
+	}
+	return pkgs, nil
+}
+
+/*
+ * path -> symlink target (empty for files) for each line in a package blob
+ */
+func readPkgFiles(repo *gitobj.Repo, hash gitobj.Hash) (map[string]string, error) {
+	data, err := repo.ReadBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		path, target := splitTarget(string(line))
+		files[path] = target
+	}
+	return files, nil
+}
+
+func diffPkgFiles(change *PkgChange, old, new map[string]string) {
+	for path, target := range new {
+		otarget, ok := old[path]
+		if !ok {
+			change.AddedPaths = append(change.AddedPaths, path)
+		} else if otarget != target {
+			change.ChangedLinks = append(change.ChangedLinks, LinkChange{path, otarget, target})
+		}
+	}
+	for path := range old {
+		if _, ok := new[path]; !ok {
+			change.RemovedPaths = append(change.RemovedPaths, path)
+		}
+	}
+	sort.Strings(change.AddedPaths)
+	sort.Strings(change.RemovedPaths)
+	sort.Slice(change.ChangedLinks, func(i1, i2 int) bool {
+		return change.ChangedLinks[i1].Path < change.ChangedLinks[i2].Path
+	})
+}
+
+/*
+ * compare the trees of two commits
+ * if allPaths is set, the paths of added and removed packages are listed too
+ */
+func diffCommits(repo *gitobj.Repo, oldCommit, newCommit *gitobj.Commit, allPaths bool) (*ChangeSet, error) {
+	cs := &ChangeSet{
+		OldCommit:       oldCommit.Hash.String(),
+		NewCommit:       newCommit.Hash.String(),
+		OldLastModified: formatLastModified(oldCommit.AuthorTime),
+		NewLastModified: formatLastModified(newCommit.AuthorTime),
+		Added:           []PkgChange{},
+		Updated:         []PkgChange{},
+		Removed:         []PkgChange{},
+	}
+	oldPkgs, err := readTreePkgs(repo, oldCommit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	newPkgs, err := readTreePkgs(repo, newCommit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	for pkgname, pkg := range newPkgs {
+		opkg, ok := oldPkgs[pkgname]
+		if !ok {
+			change := PkgChange{Name: pkgname, New: pkg.pkgver}
+			if allPaths {
+				files, err := readPkgFiles(repo, pkg.hash)
+				if err != nil {
+					return nil, err
+				}
+				diffPkgFiles(&change, nil, files)
+			}
+			cs.Added = append(cs.Added, change)
+			continue
+		}
+		if opkg.hash == pkg.hash {
+			continue
+		}
+		change := PkgChange{Name: pkgname, Old: opkg.pkgver, New: pkg.pkgver}
+		oldFiles, err := readPkgFiles(repo, opkg.hash)
+		if err != nil {
+			return nil, err
+		}
+		newFiles, err := readPkgFiles(repo, pkg.hash)
+		if err != nil {
+			return nil, err
+		}
+		diffPkgFiles(&change, oldFiles, newFiles)
+		cs.Updated = append(cs.Updated, change)
+	}
+	for pkgname, opkg := range oldPkgs {
+		if _, ok := newPkgs[pkgname]; !ok {
+			change := PkgChange{Name: pkgname, Old: opkg.pkgver}
+			if allPaths {
+				files, err := readPkgFiles(repo, opkg.hash)
+				if err != nil {
+					return nil, err
+				}
+				diffPkgFiles(&change, files, nil)
+			}
+			cs.Removed = append(cs.Removed, change)
+		}
+	}
+	cs.sort()
+	return cs, nil
+}
+
+func (self *ChangeSet) sort() {
+	for _, changes := range [][]PkgChange{self.Added, self.Updated, self.Removed} {
+		sort.Slice(changes, func(i1, i2 int) bool {
+			return changes[i1].Name < changes[i2].Name
+		})
+	}
+}
+
+/*
+ * compare two commits of the repo
+ *
+ * both can be anything GetAt() accepts
+ * if from is empty, to is compared with its first parent
+ */
+func (self *Xldb) Diff(from, to string) (*ChangeSet, error) {
+	repo, release, err := self.openRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	newCommit, err := resolveRev(repo, to)
+	if err != nil {
+		return nil, err
+	}
+	var oldCommit *gitobj.Commit
+	if from == "" {
+		if len(newCommit.Parents) == 0 {
+			return nil, fmt.Errorf("commit %s has no parent", newCommit.Hash)
+		}
+		oldCommit, err = repo.ReadCommit(newCommit.Parents[0])
+	} else {
+		oldCommit, err = resolveRev(repo, from)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return diffCommits(repo, oldCommit, newCommit, false)
+}
+
+/*
+ * change sets for the last n commits of the loaded generation, newest first
+ *
+ * unlike GetChanges() these are rebuilt from the git history, so they
+ * survive restarts and there's one for every commit even if a reload
+ * skipped some
+ */
+func (self *Xldb) RecentChanges(n int) ([]*ChangeSet, error) {
+	gen := self.Get()
+	if gen.Commit == "" {
+		return nil, fmt.Errorf("not loaded from a git repo")
+	}
+	hash, err := gitobj.ParseHash(gen.Commit)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, release, err := self.openRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	cache := &self.recentChanges
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	changes := make([]*ChangeSet, 0, n)
+	used := make(map[gitobj.Hash]*ChangeSet, n)
+	for len(changes) < n {
+		commit, err := repo.ReadCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents) == 0 {
+			break
+		}
+		cs := cache.entries[hash]
+		if cs == nil {
+			parent, err := repo.ReadCommit(commit.Parents[0])
+			if err != nil {
+				return nil, err
+			}
+			if cs, err = diffCommits(repo, parent, commit, true); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, cs)
+		used[hash] = cs
+		hash = commit.Parents[0]
+	}
+	// forget the ones that fell off the end
+	cache.entries = used
+
+	return changes, nil
+}
+
+type changeCache struct {
+	mutex   sync.Mutex
+	entries map[gitobj.Hash]*ChangeSet
+}
+
+/*
+ * keep only the changes to package pkgname (if not empty) that involve path
+ * (if not empty)
+ *
+ * returns nil if nothing is left
+ */
+func (self *ChangeSet) Filter(pkgname string, path string) *ChangeSet {
+	filter := func(changes []PkgChange) []PkgChange {
+		rv := make([]PkgChange, 0)
+		for _, change := range changes {
+			if pkgname != "" && change.Name != pkgname {
+				continue
+			}
+			if path != "" {
+				change = change.filterPath(path)
+				if change.IsEmpty() {
+					continue
+				}
+			}
+			rv = append(rv, change)
+		}
+		return rv
+	}
+	cs := *self
+	cs.Added = filter(self.Added)
+	cs.Updated = filter(self.Updated)
+	cs.Removed = filter(self.Removed)
+	if cs.IsEmpty() {
+		return nil
+	}
+	return &cs
+}
+
+func (self PkgChange) IsEmpty() bool {
+	return len(self.AddedPaths) == 0 && len(self.RemovedPaths) == 0 && len(self.ChangedLinks) == 0
+}
+
+func (self PkgChange) filterPath(path string) PkgChange {
+	change := PkgChange{Name: self.Name, Old: self.Old, New: self.New}
+	for _, p := range self.AddedPaths {
+		if p == path {
+			change.AddedPaths = append(change.AddedPaths, p)
+		}
+	}
+	for _, p := range self.RemovedPaths {
+		if p == path {
+			change.RemovedPaths = append(change.RemovedPaths, p)
+		}
+	}
+	for _, link := range self.ChangedLinks {
+		if link.Path == path {
+			change.ChangedLinks = append(change.ChangedLinks, link)
+		}
+	}
+	return change
+}
+
+/*
+ * path -> symlink target (empty for files) for everything pkgver owns in a
+ * generation, like readPkgFiles()
+ */
+func (self *Gen) pkgFiles(pkgver Pkgver) map[string]string {
+	files := make(map[string]string)
+	if pkg, ok := self.pkgIds[pkgver]; ok {
+		self.vfsCollectPkgFiles(VFS_ROOT, pkg, "", files)
+	}
+	return files
+}
+
+func (self *Gen) vfsCollectPkgFiles(vfs Vfs, pkg pkgId, path string, files map[string]string) {
+	vtype := self.vfsGetOwner(vfs, pkg)
+	switch {
+	case vtype.IsDir():
+		for name, cvfs := range self.nodes[vfs].children {
+			if self.vfsGetOwner(cvfs, pkg).Ok() {
+				self.vfsCollectPkgFiles(cvfs, pkg, path+"/"+name, files)
+			}
+		}
+	case vtype.IsFile():
+		files[path] = ""
+	case vtype.IsLink():
+		files[path] = vtype.GetTarget()
+	}
+}
+
+/*
+ * compare two generations
+ * packages with the same version in both are assumed to be unchanged
+ */
+func diffGens(old, gen *Gen) *ChangeSet {
+	loaded := time.Now().UTC()
+	cs := &ChangeSet{
+		Loaded:          &loaded,
+		OldCommit:       old.Commit,
+		NewCommit:       gen.Commit,
+		OldLastModified: old.LastModified,
+		NewLastModified: gen.LastModified,
+		Added:           []PkgChange{},
+		Updated:         []PkgChange{},
+		Removed:         []PkgChange{},
+	}
+	for pkgname, version := range gen.pkgs {
+		oversion := old.pkgs[pkgname]
+		if oversion == "" {
+			change := PkgChange{Name: pkgname, New: JoinPkgver(pkgname, version)}
+			diffPkgFiles(&change, nil, gen.pkgFiles(change.New))
+			cs.Added = append(cs.Added, change)
+		} else if oversion != version {
+			change := PkgChange{
+				Name: pkgname,
+				Old:  JoinPkgver(pkgname, oversion),
+				New:  JoinPkgver(pkgname, version),
+			}
+			diffPkgFiles(&change, old.pkgFiles(change.Old), gen.pkgFiles(change.New))
+			cs.Updated = append(cs.Updated, change)
+		}
+	}
+	for pkgname, oversion := range old.pkgs {
+		if gen.pkgs[pkgname] == "" {
+			change := PkgChange{Name: pkgname, Old: JoinPkgver(pkgname, oversion)}
+			diffPkgFiles(&change, old.pkgFiles(change.Old), nil)
+			cs.Removed = append(cs.Removed, change)
+		}
+	}
+	cs.sort()
+	return cs
+}
+
+func (self *Xldb) addChanges(cs *ChangeSet) {
+	self.changesMutex.Lock()
+	defer self.changesMutex.Unlock()
+	if self.ChangesSize <= 0 {
+		self.changes = nil
+		return
+	}
+	self.changes = append(self.changes, cs)
+	if over := len(self.changes) - self.ChangesSize; over > 0 {
+		self.changes = append([]*ChangeSet{}, self.changes[over:]...)
+	}
+}
+
+/*
+ * get the change sets from the last self.ChangesSize reloads, newest first
+ */
+func (self *Xldb) GetChanges() []*ChangeSet {
+	self.changesMutex.Lock()
+	defer self.changesMutex.Unlock()
+	changes := make([]*ChangeSet, len(self.changes))
+	for i, cs := range self.changes {
+		changes[len(changes)-1-i] = cs
+	}
+	return changes
+}
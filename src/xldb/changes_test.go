@@ -0,0 +1,77 @@
+package xldb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddChangesSize(t *testing.T) {
+	for _, size := range []int{-1, 0, 1, 3} {
+		xd := &Xldb{ChangesSize: size}
+		for i := 0; i < 5; i++ {
+			xd.addChanges(&ChangeSet{NewCommit: string(rune('a' + i))})
+		}
+		want := size
+		if want < 0 {
+			want = 0
+		}
+		changes := xd.GetChanges()
+		if len(changes) != want {
+			t.Errorf("ChangesSize %d: kept %d change sets, want %d", size, len(changes), want)
+		} else if want > 0 && changes[0].NewCommit != "e" {
+			t.Errorf("ChangesSize %d: newest is %q, want \"e\"", size, changes[0].NewCommit)
+		}
+	}
+}
+
+func TestDiffGensPaths(t *testing.T) {
+	old := testGen(t, testList)
+	// zlib goes away and libressl is new
+	list := ""
+	for _, line := range strings.SplitAfter(testList, "\n") {
+		if !strings.HasPrefix(line, "zlib-1.2.11_1,") {
+			list += line
+		}
+	}
+	gen := testGen(t, list+"libressl-3.3.0_1,/usr/lib/libtls.so.20\n")
+
+	cs := diffGens(old, gen)
+	if len(cs.Added) != 1 || cs.Added[0].Name != "libressl" {
+		t.Fatalf("added: %+v", cs.Added)
+	}
+	if got := cs.Added[0].AddedPaths; len(got) != 1 || got[0] != "/usr/lib/libtls.so.20" {
+		t.Errorf("libressl: added paths %v", got)
+	}
+	if len(cs.Removed) != 1 || cs.Removed[0].Name != "zlib" {
+		t.Fatalf("removed: %+v", cs.Removed)
+	}
+	want := []string{"/usr/lib/libz.so.1", "/usr/lib/libz.so.1.2.11", "/usr/lib/weird dir/a&b<c>.txt"}
+	if got := cs.Removed[0].RemovedPaths; !reflect.DeepEqual(got, want) {
+		t.Errorf("zlib: removed paths %v, want %v", got, want)
+	}
+}
+
+func TestFilterPathOfAddedPackage(t *testing.T) {
+	old := testGen(t, testList)
+	gen := testGen(t, testList+"libressl-3.3.0_1,/usr/lib/libtls.so.20\n")
+	cs := diffGens(old, gen)
+
+	filtered := cs.Filter("", "/usr/lib/libtls.so.20")
+	if filtered == nil {
+		t.Fatal("no changes left after filtering by a path of the added package")
+	}
+	if len(filtered.Added) != 1 || filtered.Added[0].Name != "libressl" ||
+		len(filtered.Updated) != 0 || len(filtered.Removed) != 0 {
+		t.Errorf("filtered: %+v", filtered)
+	}
+	if cs.Filter("", "/usr/lib/libssl.so.3") != nil {
+		t.Errorf("a path nothing changed still has changes")
+	}
+
+	// and the other way around
+	cs = diffGens(gen, old)
+	if filtered := cs.Filter("", "/usr/lib/libtls.so.20"); filtered == nil || len(filtered.Removed) != 1 {
+		t.Errorf("filtering by a path of the removed package: %+v", filtered)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * finding the packages that have a command
+ */
+
+package xldb
+
+import (
+	"sort"
+	"strings"
+)
+
+// where commands are looked for, in order
+var CommandDirs = []string{"/usr/bin", "/usr/sbin", "/bin", "/sbin", "/usr/libexec"}
+
+type CommandMatch struct {
+	Path   string // after following links to the dir it's in
+	Pkgver Pkgver
+	Type   VfsType
+}
+
+/*
+ * find the files and links named name in CommandDirs
+ *
+ * dirs that are links (like /bin -> usr/bin) are followed the same way
+ * VfsLinkResolveTarget does, and each dir is only looked in once
+ */
+func (self *Gen) FindCommand(name string) []CommandMatch {
+	rv := make([]CommandMatch, 0)
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return rv
+	}
+	seen := make(map[Vfs]bool)
+	for _, path := range CommandDirs {
+		dir := self.VfsDirFollowPath(VFS_ROOT, path)
+		if dir == VFS_NONE {
+			continue
+		}
+		if dir = self.VfsDirResolve(dir, 3); dir == VFS_NONE || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		cvfs, ok := self.nodes[dir].children[name]
+		if !ok {
+			continue
+		}
+		cpath := self.VfsGetPath(cvfs)
+		for _, owner := range self.VfsGetOwners(cvfs) {
+			// a dir isn't something you can run
+			if owner.Type.IsDir() {
+				continue
+			}
+			rv = append(rv, CommandMatch{cpath, owner.Pkgver, owner.Type})
+		}
+	}
+	sort.SliceStable(rv, func(i1, i2 int) bool {
+		return rv[i1].Pkgver < rv[i2].Pkgver
+	})
+	return rv
+}
@@ -0,0 +1,227 @@
+/*
+ * finding the packages a failed build was missing from its log
+ */
+
+package xldb
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type LogMissingKind string
+
+const (
+	LOG_HEADER    = LogMissingKind("header")    // #include that wasn't found
+	LOG_PKGCONFIG = LogMissingKind("pkgconfig") // pkg-config module
+	LOG_PROGRAM   = LogMissingKind("program")   // command that wasn't found
+)
+
+// where the files for each kind are looked for
+var LogIncludeDirs = []string{"/usr/include"}
+var LogPkgconfigDirs = []string{"/usr/lib/pkgconfig", "/usr/share/pkgconfig"}
+
+// most matches kept for one name
+const logMaxMatches = 20
+
+// headers that aren't directly in an include dir are searched for, this
+// many at most and for this long in total per log, the rest are skipped
+const logMaxSearches = 10
+const logSearchTime = 3 * time.Second
+
+type LogMatch struct {
+	Path   string
+	Pkgver Pkgver
+	Type   VfsType
+}
+
+type LogMissing struct {
+	Kind    LogMissingKind
+	Name    string
+	Line    int        // first line that mentions it
+	Pkgname string     // the package to add, empty if nothing has it
+	Matches []LogMatch // -devel packages first
+	Skipped bool       // not searched for, the log had too many
+}
+
+/*
+ * what's left of the search limits for one log
+ */
+type logSearchBudget struct {
+	searches int
+	deadline time.Time
+}
+
+/*
+ * how long the next search can take, 0 if there's nothing left
+ */
+func (self *logSearchBudget) next() time.Duration {
+	left := time.Until(self.deadline)
+	if self.searches <= 0 || left <= 0 {
+		return 0
+	}
+	self.searches -= 1
+	if left > time.Second {
+		left = time.Second
+	}
+	return left
+}
+
+type logPattern struct {
+	kind LogMissingKind
+	re   *regexp.Regexp
+}
+
+var logPatterns = []logPattern{
+	// gcc, clang
+	{LOG_HEADER, regexp.MustCompile(`fatal error: ([^\s':]+): No such file or directory`)},
+	{LOG_HEADER, regexp.MustCompile(`fatal error: '([^\s']+)' file not found`)},
+	// pkg-config, meson
+	{LOG_PKGCONFIG, regexp.MustCompile(`Package '([^\s']+)', required by .*, not found`)},
+	{LOG_PKGCONFIG, regexp.MustCompile(`Package ([^\s']+) was not found in the pkg-config search path`)},
+	{LOG_PKGCONFIG, regexp.MustCompile(`No package '([^\s']+)' found`)},
+	{LOG_PKGCONFIG, regexp.MustCompile(`Dependency ["']([^\s"']+)["'] (?:is required but )?not found`)},
+	// bash, dash, make, env, meson
+	{LOG_PROGRAM, regexp.MustCompile(`: ([^\s:/']+): (?:[Cc]ommand )?not found\s*$`)},
+	{LOG_PROGRAM, regexp.MustCompile(`env: '([^\s:/']+)': No such file or directory`)},
+	{LOG_PROGRAM, regexp.MustCompile(`Program(?:\(s\))? \[?'([^\s:/']+)'\]? not found`)},
+}
+
+/*
+ * find the missing headers, pkg-config modules and programs mentioned in a
+ * build log, each one once in the order they first appear
+ */
+func ParseBuildLog(r io.Reader) ([]LogMissing, error) {
+	rv := make([]LogMissing, 0)
+	seen := make(map[LogMissingKind]map[string]bool)
+	br := bufio.NewReader(r)
+	for lineno := 1; ; lineno++ {
+		line, err := br.ReadString('\n')
+		if line == "" && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		for _, p := range logPatterns {
+			m := p.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			if seen[p.kind] == nil {
+				seen[p.kind] = make(map[string]bool)
+			}
+			if !seen[p.kind][m[1]] {
+				seen[p.kind][m[1]] = true
+				rv = append(rv, LogMissing{Kind: p.kind, Name: m[1], Line: lineno})
+			}
+			break
+		}
+	}
+	return rv, nil
+}
+
+func (self *Gen) logMatchPath(matches []LogMatch, vfs Vfs) []LogMatch {
+	path := self.VfsGetPath(vfs)
+	for _, owner := range self.VfsGetOwners(vfs) {
+		if !owner.Type.IsDir() {
+			matches = append(matches, LogMatch{path, owner.Pkgver, owner.Type})
+		}
+	}
+	return matches
+}
+
+/*
+ * the second return value is true if there was no time left to search
+ */
+func (self *Gen) logFindHeader(name string, budget *logSearchBudget) ([]LogMatch, bool) {
+	matches := make([]LogMatch, 0)
+	for _, dir := range LogIncludeDirs {
+		if vfs := self.VfsResolvePath(dir + "/" + name); vfs != VFS_NONE {
+			matches = self.logMatchPath(matches, vfs)
+		}
+	}
+	if len(matches) != 0 {
+		return matches, false
+	}
+	// not directly in the include dir, it might be in a subdir that's
+	// added with -I (like /usr/include/glib-2.0/glib.h)
+	for _, dir := range LogIncludeDirs {
+		timeout := budget.next()
+		if timeout == 0 {
+			return matches, true
+		}
+		expr := "^" + regexp.QuoteMeta(dir) + "/.*/" + regexp.QuoteMeta(name) + "$"
+		result, err := self.Search(SEARCH_REGEX, expr, logMaxMatches, timeout)
+		if err != nil {
+			continue
+		}
+		for _, m := range result.Matches {
+			matches = self.logMatchPath(matches, m.Vfs)
+		}
+	}
+	return matches, false
+}
+
+func (self *Gen) logFindPkgconfig(name string) []LogMatch {
+	matches := make([]LogMatch, 0)
+	for _, dir := range LogPkgconfigDirs {
+		if vfs := self.VfsResolvePath(dir + "/" + name + ".pc"); vfs != VFS_NONE {
+			matches = self.logMatchPath(matches, vfs)
+		}
+	}
+	return matches
+}
+
+func (self *Gen) logFindProgram(name string) []LogMatch {
+	matches := make([]LogMatch, 0)
+	for _, cmd := range self.FindCommand(name) {
+		matches = append(matches, LogMatch{cmd.Path, cmd.Pkgver, cmd.Type})
+	}
+	return matches
+}
+
+/*
+ * parse a build log and find the packages that have what it was missing
+ *
+ * -devel packages are preferred since that's where headers and .pc files
+ * for makedepends are, other packages that have the same thing are listed
+ * after them
+ *
+ * headers that need searching for past logMaxSearches or logSearchTime
+ * are marked as skipped
+ */
+func (self *Gen) FromLog(r io.Reader) ([]LogMissing, error) {
+	missing, err := ParseBuildLog(r)
+	if err != nil {
+		return nil, err
+	}
+	budget := &logSearchBudget{logMaxSearches, time.Now().Add(logSearchTime)}
+	for i := range missing {
+		m := &missing[i]
+		switch m.Kind {
+		case LOG_HEADER:
+			m.Matches, m.Skipped = self.logFindHeader(m.Name, budget)
+		case LOG_PKGCONFIG:
+			m.Matches = self.logFindPkgconfig(m.Name)
+		case LOG_PROGRAM:
+			m.Matches = self.logFindProgram(m.Name)
+		}
+		sort.SliceStable(m.Matches, func(i1, i2 int) bool {
+			d1 := strings.HasSuffix(m.Matches[i1].Pkgver.Name(), "-devel")
+			d2 := strings.HasSuffix(m.Matches[i2].Pkgver.Name(), "-devel")
+			return d1 && !d2
+		})
+		if len(m.Matches) > logMaxMatches {
+			m.Matches = m.Matches[0:logMaxMatches]
+		}
+		if len(m.Matches) != 0 {
+			m.Pkgname = m.Matches[0].Pkgver.Name()
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,37 @@
+package xldb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFromLogSearchLimit(t *testing.T) {
+	gen := testGen(t, testList)
+	var log strings.Builder
+	fmt.Fprintf(&log, "foo.c:1:10: fatal error: zlib.h: No such file or directory\n")
+	fmt.Fprintf(&log, "foo.c:2:10: fatal error: ssl.h: No such file or directory\n")
+	for i := 0; i < logMaxSearches+5; i++ {
+		fmt.Fprintf(&log, "foo.c:%d:10: fatal error: missing%d.h: No such file or directory\n", i+3, i)
+	}
+	missing, err := gen.FromLog(strings.NewReader(log.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != logMaxSearches+7 {
+		t.Fatalf("got %d missing headers, want %d", len(missing), logMaxSearches+7)
+	}
+	// directly in /usr/include, doesn't need a search
+	if m := missing[0]; m.Pkgname != "zlib-devel" || m.Skipped {
+		t.Errorf("zlib.h: got %+v", m)
+	}
+	// in a subdir, needs one
+	if m := missing[1]; m.Pkgname != "openssl-devel" || m.Skipped {
+		t.Errorf("ssl.h: got %+v", m)
+	}
+	for i, m := range missing[2:] {
+		if want := i+1 >= logMaxSearches; m.Skipped != want || m.Pkgname != "" {
+			t.Errorf("%s: skipped is %t, want %t", m.Name, m.Skipped, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * old generations loaded on demand from the history of the git repo
+ */
+
+package xldb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+import "gitobj"
+
+type historyEntry struct {
+	gen  *Gen
+	err  error
+	done chan struct{} // closed when gen/err are set
+	used int64         // for picking the least recently used one
+}
+
+type history struct {
+	mutex   sync.Mutex
+	entries map[gitobj.Hash]*historyEntry
+	clock   int64
+
+	// these use a lot of memory so only load one at a time
+	loadMutex sync.Mutex
+
+	repo *historyRepo
+}
+
+/*
+ * the repo opened for requests, shared until a reload changes the commit
+ * so that packs added by a fetch get seen
+ */
+type historyRepo struct {
+	repo   *gitobj.Repo
+	commit string // Gen.Commit when it was opened
+	users  int
+	stale  bool // replaced by a newer one, close when unused
+}
+
+var ErrHistoryBusy = errors.New("another old version is being loaded, try again in a bit")
+
+var revDateFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+/*
+ * parse a date for GetAt()
+ * dates without a time refer to the end of that day (in UTC)
+ */
+func parseRevDate(rev string) (time.Time, bool) {
+	for _, format := range revDateFormats {
+		if t, err := time.Parse(format, rev); err == nil {
+			if format == "2006-01-02" {
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+/*
+ * resolve a revision or date to a commit in the repo
+ */
+func resolveRev(repo *gitobj.Repo, rev string) (*gitobj.Commit, error) {
+	if t, ok := parseRevDate(rev); ok {
+		head, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+		return repo.FindCommitBefore(head, t)
+	}
+	hash, err := repo.ResolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+	return repo.ReadCommit(hash)
+}
+
+/*
+ * get the shared repo, call release() when done with it
+ */
+func (self *Xldb) openRepo() (*gitobj.Repo, func(), error) {
+	commit := self.Get().Commit
+	h := &self.history
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.repo == nil || h.repo.commit != commit {
+		repo, err := gitobj.Open(self.Repo)
+		if err != nil {
+			return nil, nil, err
+		}
+		if old := h.repo; old != nil {
+			old.stale = true
+			if old.users == 0 {
+				old.repo.Close()
+			}
+		}
+		h.repo = &historyRepo{repo: repo, commit: commit}
+	}
+	hr := h.repo
+	hr.users += 1
+	release := func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		hr.users -= 1
+		if hr.stale && hr.users == 0 {
+			hr.repo.Close()
+		}
+	}
+	return hr.repo, release, nil
+}
+
+/*
+ * get the generation for a commit in the git repo
+ *
+ * rev is anything gitobj.ResolveRevision() accepts, or a date like
+ * "2021-03-01" or "2021-03-01T12:00:00Z" for the last commit made before it
+ *
+ * the last self.HistorySize of these are kept in memory
+ * only one is loaded at a time, others get ErrHistoryBusy meanwhile
+ */
+func (self *Xldb) GetAt(rev string) (*Gen, error) {
+	if self.HistorySize <= 0 {
+		return nil, fmt.Errorf("history is disabled")
+	}
+
+	repo, release, err := self.openRepo()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	commit, err := resolveRev(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	if current := self.Get(); current.Commit == commit.Hash.String() {
+		return current, nil
+	}
+
+	h := &self.history
+	h.mutex.Lock()
+	h.clock += 1
+	entry := h.entries[commit.Hash]
+	if entry != nil {
+		entry.used = h.clock
+		h.mutex.Unlock()
+		<-entry.done
+		return entry.gen, entry.err
+	}
+	if !h.loadMutex.TryLock() {
+		h.mutex.Unlock()
+		return nil, ErrHistoryBusy
+	}
+	entry = &historyEntry{done: make(chan struct{}), used: h.clock}
+	h.entries[commit.Hash] = entry
+	h.evict(self.HistorySize)
+	h.mutex.Unlock()
+
+	entry.gen, entry.err = self.loadCommit(repo, commit)
+	h.loadMutex.Unlock()
+	close(entry.done)
+
+	if entry.err != nil {
+		// don't keep errors around, they might be temporary
+		h.mutex.Lock()
+		if h.entries[commit.Hash] == entry {
+			delete(h.entries, commit.Hash)
+		}
+		h.mutex.Unlock()
+	}
+
+	return entry.gen, entry.err
+}
+
+/*
+ * remove the least recently used entries until there are at most max
+ * call with self.mutex held
+ */
+func (self *history) evict(max int) {
+	for len(self.entries) > max {
+		var oldest gitobj.Hash
+		var oldestEntry *historyEntry
+		for hash, entry := range self.entries {
+			if oldestEntry == nil || entry.used < oldestEntry.used {
+				oldest, oldestEntry = hash, entry
+			}
+		}
+		delete(self.entries, oldest)
+	}
+}
+
+func (self *Xldb) loadCommit(repo *gitobj.Repo, commit *gitobj.Commit) (*Gen, error) {
+	fmt.Fprintf(self.Log, "xldb: loading commit %s\n", commit.Hash)
+
+	src, err := newTreeScanner(repo, commit.Tree, self.Log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file list: %s", err)
+	}
+	var lines, pkgs int64
+	gen, err := buildGen(src, &lines, &pkgs)
+	if err != nil {
+		return nil, err
+	}
+	gen.LastModified = formatLastModified(commit.AuthorTime)
+	gen.Commit = commit.Hash.String()
+	return gen, nil
+}
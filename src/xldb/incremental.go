@@ -0,0 +1,153 @@
+/*
+ * reloading only the packages that changed since the loaded commit
+ */
+
+package xldb
+
+import (
+	"fmt"
+	"sort"
+)
+
+import "gitobj"
+
+/*
+ * blob name -> hash for every blob in a tree
+ */
+func readTreeBlobs(repo *gitobj.Repo, tree gitobj.Hash) (map[string]gitobj.Hash, error) {
+	blobs, err := listTree(repo, tree, "")
+	if err != nil {
+		return nil, err
+	}
+	rv := make(map[string]gitobj.Hash, len(blobs))
+	for _, blob := range blobs {
+		rv[blob.Name] = blob.Hash
+	}
+	return rv, nil
+}
+
+/*
+ * a deep copy of a generation that can be modified before publishing it
+ */
+func (self *Gen) clone() *Gen {
+	gen := newGen()
+	gen.LastModified = self.LastModified
+	gen.Commit = self.Commit
+	for pkgname, version := range self.pkgs {
+		gen.pkgs[pkgname] = version
+	}
+	gen.nodes = make([]vfsNode, len(self.nodes))
+	for i, node := range self.nodes {
+		if node.children != nil {
+			children := make(map[string]Vfs, len(node.children))
+			for name, cvfs := range node.children {
+				children[name] = cvfs
+			}
+			node.children = children
+		}
+		owners := make([]vfsOwner, len(node.owners))
+		copy(owners, node.owners)
+		node.owners = owners
+		gen.nodes[i] = node
+	}
+	gen.free = append([]Vfs(nil), self.free...)
+	// updated by indexGen() before publishing
+	gen.index = self.index
+	gen.pkgvers = append([]Pkgver(nil), self.pkgvers...)
+	for pkgver, pkg := range self.pkgIds {
+		gen.pkgIds[pkgver] = pkg
+	}
+	gen.freePkgs = append([]pkgId(nil), self.freePkgs...)
+	gen.vtypes = append([]VfsType(nil), self.vtypes...)
+	for vtype, vt := range self.vtypeIds {
+		gen.vtypeIds[vtype] = vt
+	}
+	return gen
+}
+
+/*
+ * set the version of each of pkgnames to the one a full load would end up
+ * with, the last of its pkgvers in tree order (there can be more than one,
+ * like the 32-bit builds of some packages), or remove it if none are left
+ */
+func (self *Gen) updatePkgs(pkgnames map[string]bool) {
+	latest := make(map[string]Pkgver, len(pkgnames))
+	for pkgver := range self.pkgIds {
+		pkgname, _ := pkgver.Split()
+		if pkgnames[pkgname] && pkgver > latest[pkgname] {
+			latest[pkgname] = pkgver
+		}
+	}
+	for pkgname := range pkgnames {
+		if pkgver, ok := latest[pkgname]; ok {
+			_, self.pkgs[pkgname] = pkgver.Split()
+		} else {
+			delete(self.pkgs, pkgname)
+		}
+	}
+}
+
+/*
+ * update a copy of old to commit by re-reading only the package blobs that
+ * are different between the two commits, then publish it
+ */
+func (self *Xldb) loadIncremental(repo *gitobj.Repo, old *Gen, commit *gitobj.Commit) (*ChangeSet, error) {
+	oldHash, err := gitobj.ParseHash(old.Commit)
+	if err != nil {
+		return nil, err
+	}
+	oldCommit, err := repo.ReadCommit(oldHash)
+	if err != nil {
+		return nil, err
+	}
+	oldBlobs, err := readTreeBlobs(repo, oldCommit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	newBlobs, err := readTreeBlobs(repo, commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0)
+	for name, hash := range oldBlobs {
+		if newBlobs[name] != hash && isValidPkgver(name) {
+			removed = append(removed, name)
+		}
+	}
+	added := make([]gitobj.TreeEntry, 0)
+	for name, hash := range newBlobs {
+		if oldBlobs[name] != hash {
+			added = append(added, gitobj.TreeEntry{Name: name, Hash: hash})
+		}
+	}
+	sort.Slice(added, func(i1, i2 int) bool {
+		return added[i1].Name < added[i2].Name
+	})
+	fmt.Fprintf(self.Log, "xldb: %d package files removed, %d added\n", len(removed), len(added))
+
+	gen := old.clone()
+	pkgnames := make(map[string]bool)
+	for _, name := range removed {
+		pkgver := Pkgver(name)
+		if pkg, ok := gen.pkgIds[pkgver]; ok {
+			gen.vfsEradicatePkgver(VFS_ROOT, pkg)
+			gen.removePkgver(pkg)
+		}
+		pkgname, _ := pkgver.Split()
+		pkgnames[pkgname] = true
+	}
+	for _, entry := range added {
+		pkgname, _ := Pkgver(entry.Name).Split()
+		pkgnames[pkgname] = true
+	}
+	src := &treeScanner{repo: repo, blobs: added, log: self.Log}
+	if err := gen.addLines(src, &self.progress_lines, &self.progress_pkgs); err != nil {
+		return nil, err
+	}
+	gen.updatePkgs(pkgnames)
+	gen.LastModified = formatLastModified(commit.AuthorTime)
+	gen.Commit = commit.Hash.String()
+
+	return self.publish(gen), nil
+}
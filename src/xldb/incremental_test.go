@@ -0,0 +1,96 @@
+package xldb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+ * each commit replaces the package files in the repo with these, in the
+ * same layout as the xlocate repo (one file per pkgver)
+ */
+var incrementalCommits = []map[string]string{
+	{
+		"glibc-2.32_1":  "/usr/lib/libc.so.6\n/usr/bin/ldd\n",
+		"notion-1.0_1":  "/usr/bin/notion\n/usr/share/notion/a\n",
+		"notion-1.1_1":  "/usr/bin/notion\n/usr/share/notion/b\n",
+		"zlib-1.2.11_1": "/usr/lib/libz.so.1 -> libz.so.1.2.11\n/usr/lib/libz.so.1.2.11\n",
+	},
+	// the last version of notion goes away but an older one is still there
+	{
+		"glibc-2.32_1":  "/usr/lib/libc.so.6\n/usr/bin/ldd\n",
+		"notion-1.0_1":  "/usr/bin/notion\n/usr/share/notion/a\n",
+		"zlib-1.2.11_1": "/usr/lib/libz.so.1 -> libz.so.1.2.11\n/usr/lib/libz.so.1.2.11\n",
+	},
+	// a version that sorts before the one that's already there
+	{
+		"glibc-2.33_1":  "/usr/lib/libc.so.6\n/usr/bin/ldd\n/usr/bin/iconv\n",
+		"notion-0.9_1":  "/usr/bin/notion\n",
+		"notion-1.0_1":  "/usr/bin/notion\n/usr/share/notion/a\n",
+		"zlib-1.2.11_1": "/usr/lib/libz.so.1 -> libz.so.1.2.11\n/usr/lib/libz.so.1.2.11\n",
+	},
+	// both removed
+	{
+		"glibc-2.33_1":  "/usr/lib/libc.so.6\n/usr/bin/ldd\n/usr/bin/iconv\n",
+		"zlib-1.2.12_1": "/usr/lib/libz.so.1 -> libz.so.1.2.12\n/usr/lib/libz.so.1.2.12\n",
+	},
+}
+
+func TestIncrementalMatchesFullLoad(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	testGit(t, dir, nil, "init", "-q")
+
+	incr := &Xldb{}
+	incr.Init()
+	incr.Log = ioutil.Discard
+	incr.Repo = dir
+	incr.SnapshotPath = ""
+	incr.PathIndex = false
+
+	for i, files := range incrementalCommits {
+		old, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, path := range old {
+			os.Remove(path)
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		date := fmt.Sprintf("GIT_AUTHOR_DATE=2021-03-0%dT12:00:00Z", i+1)
+		testGit(t, dir, nil, "add", "-A")
+		testGit(t, dir, []string{date}, "commit", "-q", "--allow-empty", "-m", fmt.Sprintf("commit %d", i))
+
+		if _, err := incr.Load(); err != nil {
+			t.Fatal(err)
+		}
+		full := &Xldb{}
+		full.Init()
+		full.Log = ioutil.Discard
+		full.Repo = dir
+		full.SnapshotPath = ""
+		full.PathIndex = false
+		if _, err := full.Load(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := dumpGen(incr.Get()), dumpGen(full.Get()); got != want {
+			t.Errorf("commit %d: incremental tree differs from a full load\ngot:\n%s\nwant:\n%s", i, got, want)
+		}
+		if got, want := incr.Get().Pkgs(), full.Get().Pkgs(); !reflect.DeepEqual(got, want) {
+			t.Errorf("commit %d: incremental Pkgs() differs from a full load\ngot:  %v\nwant: %v", i, got, want)
+		}
+		// link targets that aren't used anymore shouldn't pile up
+		if got, want := len(incr.Get().vtypes), len(full.Get().vtypes); got != want {
+			t.Errorf("commit %d: %d types after an incremental load, %d after a full one", i, got, want)
+		}
+	}
+}
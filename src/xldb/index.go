@@ -0,0 +1,306 @@
+/*
+ * trigram index for narrowing down searches
+ *
+ * every node is indexed by the trigrams of "/<name>/", so a path contains a
+ * string only if each of its trigrams (that don't have a "/" in the middle)
+ * is in the name of the node or one of its parents
+ *
+ * posting lists are node ids stored as zigzag varint deltas, in no
+ * particular order so new nodes can be appended to them after incremental
+ * reloads. removed nodes are left in them until there are enough of those to
+ * make rebuilding the index worth it (the lists only need to be a superset
+ * since every candidate is checked anyway)
+ */
+
+package xldb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"time"
+)
+
+// most trigrams used for one search
+const indexMaxTrigrams = 64
+
+type posting struct {
+	last Vfs
+	data []byte
+}
+
+type pathIndex struct {
+	postings map[uint32]posting
+	nodes    int // nodes added to the lists
+	stale    int // nodes that have been removed since
+	size     int // bytes in the lists
+}
+
+func trigram(s string, i int) uint32 {
+	return uint32(s[i])<<16 | uint32(s[i+1])<<8 | uint32(s[i+2])
+}
+
+/*
+ * trigrams of s that can be looked up in the index
+ */
+func appendTrigrams(trigrams []uint32, s string) []uint32 {
+	for i := 0; i+3 <= len(s); i++ {
+		if s[i+1] == '/' {
+			continue
+		}
+		t := trigram(s, i)
+		dup := false
+		for _, t2 := range trigrams {
+			if t2 == t {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			trigrams = append(trigrams, t)
+		}
+	}
+	return trigrams
+}
+
+func (self *pathIndex) add(gen *Gen, vfs Vfs, owned map[uint32]bool) {
+	var buf [binary.MaxVarintLen64]byte
+	key := "/" + gen.nodes[vfs].name + "/"
+	for _, t := range appendTrigrams(make([]uint32, 0, len(key)), key) {
+		p := self.postings[t]
+		if !owned[t] {
+			// shared with the previous index, copy before appending
+			p.data = p.data[0:len(p.data):len(p.data)]
+			owned[t] = true
+		}
+		n := binary.PutVarint(buf[:], int64(vfs-p.last))
+		p.data = append(p.data, buf[0:n]...)
+		p.last = vfs
+		self.postings[t] = p
+		self.size += n
+	}
+	self.nodes += 1
+}
+
+func (self *pathIndex) lookup(t uint32) []Vfs {
+	p := self.postings[t]
+	rv := make([]Vfs, 0, len(p.data))
+	var last Vfs
+	for data := p.data; len(data) > 0; {
+		delta, n := binary.Varint(data)
+		data = data[n:]
+		last += Vfs(delta)
+		rv = append(rv, last)
+	}
+	return rv
+}
+
+/*
+ * approximate memory used by the index
+ */
+func (self *pathIndex) bytes() int {
+	// map entry, slice header and padding
+	const overhead = 48
+	return self.size + len(self.postings)*overhead
+}
+
+func buildPathIndex(gen *Gen) *pathIndex {
+	self := &pathIndex{postings: make(map[uint32]posting)}
+	owned := make(map[uint32]bool)
+	for i := range gen.nodes {
+		vfs := Vfs(i)
+		if vfs != VFS_ROOT && gen.nodes[vfs].parent != VFS_NONE {
+			self.add(gen, vfs, owned)
+		}
+	}
+	return self
+}
+
+/*
+ * a copy of the index with the nodes added to gen since it was cloned
+ */
+func (self *pathIndex) update(gen *Gen, added []Vfs, removed int) *pathIndex {
+	if (self.stale+removed)*4 > self.nodes {
+		return buildPathIndex(gen)
+	}
+	idx := &pathIndex{
+		postings: make(map[uint32]posting, len(self.postings)),
+		nodes:    self.nodes,
+		stale:    self.stale + removed,
+		size:     self.size,
+	}
+	for t, p := range self.postings {
+		idx.postings[t] = p
+	}
+	owned := make(map[uint32]bool)
+	for _, vfs := range added {
+		if gen.nodes[vfs].parent != VFS_NONE {
+			idx.add(gen, vfs, owned)
+		}
+	}
+	return idx
+}
+
+/*
+ * give gen a path index, or update the one it got from the generation it
+ * was cloned from
+ */
+func (self *Xldb) indexGen(gen *Gen) {
+	if !self.PathIndex {
+		gen.index = nil
+		return
+	}
+	start := time.Now()
+	if gen.index != nil {
+		gen.index = gen.index.update(gen, gen.indexAdded, gen.indexRemoved)
+	} else {
+		gen.index = buildPathIndex(gen)
+	}
+	gen.indexAdded = nil
+	gen.indexRemoved = 0
+	fmt.Fprintf(self.Log, "xldb: path index has %d trigrams, %.1f MiB (%s)\n",
+		len(gen.index.postings),
+		float64(gen.index.bytes())/(1<<20),
+		time.Since(start).Round(time.Millisecond))
+}
+
+/*
+ * size of the path index in bytes, 0 if there isn't one
+ */
+func (self *Gen) IndexSize() int {
+	if self.index == nil {
+		return 0
+	}
+	return self.index.bytes()
+}
+
+/*
+ * strings that every match of re has to contain
+ */
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		rv := make([]string, 0)
+		for _, sub := range re.Sub {
+			rv = append(rv, requiredLiterals(sub)...)
+		}
+		return rv
+	}
+	return nil
+}
+
+/*
+ * trigrams that every match of the regex has to contain
+ */
+func regexTrigrams(expr string) []uint32 {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	trigrams := make([]uint32, 0)
+	for _, lit := range requiredLiterals(re.Simplify()) {
+		trigrams = appendTrigrams(trigrams, lit)
+	}
+	if len(trigrams) > indexMaxTrigrams {
+		trigrams = trigrams[0:indexMaxTrigrams]
+	}
+	return trigrams
+}
+
+/*
+ * pick the trigrams worth looking up
+ *
+ * decoding the lists of common trigrams takes longer than checking the
+ * nodes they'd rule out, so only ones with short lists are used, and none
+ * at all if even the shortest is too long to beat a full scan
+ */
+func (self *pathIndex) selectTrigrams(trigrams []uint32) []uint32 {
+	sorted := append([]uint32(nil), trigrams...)
+	sort.Slice(sorted, func(i1, i2 int) bool {
+		return len(self.postings[sorted[i1]].data) < len(self.postings[sorted[i2]].data)
+	})
+	if len(sorted) == 0 || len(self.postings[sorted[0]].data) > self.nodes/8 {
+		return nil
+	}
+	max := 4*len(self.postings[sorted[0]].data) + 1024
+	for i, t := range sorted {
+		if len(self.postings[t].data) > max {
+			return sorted[0:i]
+		}
+	}
+	return sorted
+}
+
+/*
+ * nodes whose own names might contain all trigrams
+ * only the shortest list is used since the names are checked anyway
+ */
+func (self *Gen) indexNameCandidates(trigrams []uint32) []Vfs {
+	rv := make([]Vfs, 0)
+	seen := make(map[Vfs]bool)
+	for _, vfs := range self.index.lookup(trigrams[0]) {
+		if self.nodes[vfs].parent != VFS_NONE && !seen[vfs] {
+			seen[vfs] = true
+			rv = append(rv, vfs)
+		}
+	}
+	return rv
+}
+
+/*
+ * the topmost nodes whose paths contain all trigrams, sorted by path
+ * every node in their subtrees does too, nothing else does
+ */
+func (self *Gen) indexPathCandidates(trigrams []uint32) []Vfs {
+	masks := make(map[Vfs]uint64)
+	for i, t := range trigrams {
+		for _, vfs := range self.index.lookup(t) {
+			masks[vfs] |= 1 << uint(i)
+		}
+	}
+	full := uint64(1)<<uint(len(trigrams)) - 1
+	roots := make(map[Vfs]bool)
+	chain := make([]Vfs, 0, 16)
+	for vfs := range masks {
+		if self.nodes[vfs].parent == VFS_NONE {
+			continue
+		}
+		chain = chain[0:0]
+		for v := vfs; v != VFS_ROOT; v = self.nodes[v].parent {
+			chain = append(chain, v)
+		}
+		var mask uint64
+		for i := len(chain) - 1; i >= 0; i-- {
+			mask |= masks[chain[i]]
+			if mask == full {
+				roots[chain[i]] = true
+				break
+			}
+		}
+	}
+	rv := make([]Vfs, 0, len(roots))
+	for vfs := range roots {
+		rv = append(rv, vfs)
+	}
+	// same order every time, like the walk without the index
+	paths := make(map[Vfs]string, len(rv))
+	for _, vfs := range rv {
+		paths[vfs] = self.VfsGetPath(vfs)
+	}
+	sort.Slice(rv, func(i1, i2 int) bool {
+		return paths[rv[i1]] < paths[rv[i2]]
+	})
+	return rv
+}
@@ -0,0 +1,111 @@
+package xldb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * a list big enough for the index to rule out most nodes
+ */
+func indexTestList() string {
+	var b strings.Builder
+	for i := 0; i < 300; i++ {
+		pkgver := fmt.Sprintf("pkg%d-1.%d_1", i, i%4)
+		fmt.Fprintf(&b, "%s,/usr/bin/pkg%d\n", pkgver, i)
+		fmt.Fprintf(&b, "%s,/usr/lib/libpkg%d.so.%d\n", pkgver, i, i%3)
+		fmt.Fprintf(&b, "%s,/usr/lib/libpkg%d.so -> libpkg%d.so.%d\n", pkgver, i, i, i%3)
+		fmt.Fprintf(&b, "%s,/usr/include/pkg%d/pkg%d.h\n", pkgver, i, i)
+		for j := 0; j < 5; j++ {
+			fmt.Fprintf(&b, "%s,/usr/share/pkg%d/data/file%d.txt\n", pkgver, i, j)
+		}
+	}
+	return b.String()
+}
+
+func matchPaths(result *SearchResult) []string {
+	paths := make([]string, len(result.Matches))
+	for i, match := range result.Matches {
+		paths[i] = match.Path
+	}
+	return paths
+}
+
+func TestSearchIndexSameResults(t *testing.T) {
+	gen := testGen(t, indexTestList())
+	xd := &Xldb{PathIndex: true, Log: ioutil.Discard}
+	xd.indexGen(gen)
+	index := gen.index
+
+	tests := []struct {
+		mode    SearchMode
+		pattern string
+	}{
+		{SEARCH_NAME, "pkg17"},
+		{SEARCH_NAME, "pkg17.h"},
+		{SEARCH_NAME, "file3.txt"},
+		{SEARCH_NAME, "data"},
+		{SEARCH_NAME, "nothing-has-this"},
+		{SEARCH_SUBSTRING, "libpkg12"},
+		{SEARCH_SUBSTRING, "pkg123/data"},
+		{SEARCH_SUBSTRING, "/usr/include/pkg29"},
+		{SEARCH_SUBSTRING, "so.2"},
+		{SEARCH_SUBSTRING, "nothing-has-this"},
+		{SEARCH_GLOB, "libpkg2?.so*"},
+		{SEARCH_GLOB, "*/pkg45/*"},
+		{SEARCH_GLOB, "/usr/share/pkg1[0-9]/data/file[![:digit:]].txt"},
+		{SEARCH_GLOB, "*.h"},
+		{SEARCH_GLOB, "pkg29*"},
+		{SEARCH_REGEX, `libpkg1[0-9]\.so\.1$`},
+		{SEARCH_REGEX, `^/usr/share/pkg77/`},
+		{SEARCH_REGEX, `pkg(12|34)\.h`},
+		{SEARCH_REGEX, `file[0-4]`},
+		{SEARCH_REGEX, `(?i)PKG99`},
+	}
+	indexed := 0
+	for _, test := range tests {
+		gen.index = index
+		with, err := gen.Search(test.mode, test.pattern, 100000, time.Minute)
+		if err != nil {
+			t.Fatalf("%s %q: %s", test.mode, test.pattern, err)
+		}
+		gen.index = nil
+		without, err := gen.Search(test.mode, test.pattern, 100000, time.Minute)
+		if err != nil {
+			t.Fatalf("%s %q: %s", test.mode, test.pattern, err)
+		}
+		if !reflect.DeepEqual(matchPaths(with), matchPaths(without)) {
+			t.Errorf("%s %q: with the index got\n%v\nwithout it\n%v",
+				test.mode, test.pattern, matchPaths(with), matchPaths(without))
+		}
+		if with.Truncated || with.TimedOut || without.Truncated || without.TimedOut {
+			t.Errorf("%s %q: search didn't finish", test.mode, test.pattern)
+		}
+
+		var trigrams []uint32
+		switch test.mode {
+		case SEARCH_NAME:
+			trigrams = appendTrigrams(nil, "/"+test.pattern+"/")
+		case SEARCH_GLOB:
+			s, _ := globToRegexp(test.pattern)
+			trigrams = regexTrigrams(s)
+		case SEARCH_SUBSTRING:
+			trigrams = regexTrigrams(regexp.QuoteMeta(test.pattern))
+		default:
+			trigrams = regexTrigrams(test.pattern)
+		}
+		if len(index.selectTrigrams(trigrams)) != 0 {
+			indexed += 1
+		}
+	}
+	t.Logf("%d of %d searches used the index", indexed, len(tests))
+	// otherwise this isn't testing much
+	if indexed < len(tests)/2 {
+		t.Errorf("only %d of %d searches used the index", indexed, len(tests))
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * what an elf file needs at runtime
+ */
+
+package xldb
+
+import (
+	"debug/elf"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+type Need struct {
+	Name    string   `json:"name"`   // DT_NEEDED soname or the PT_INTERP path
+	Interp  bool     `json:"interp"` // this is the PT_INTERP
+	Found   bool     `json:"found"`
+	Path    string   `json:"path"`    // the file it resolved to, empty if it wasn't found
+	Pkgvers []Pkgver `json:"pkgvers"` // packages that have it
+}
+
+func (self *Gen) needPath(need *Need, path string) {
+	vfs := self.VfsResolvePath(path)
+	if vfs == VFS_NONE {
+		return
+	}
+	need.Found = true
+	need.Path = self.VfsGetPath(vfs)
+	for _, owner := range self.VfsGetOwners(vfs) {
+		need.Pkgvers = append(need.Pkgvers, owner.Pkgver)
+	}
+}
+
+func (self *Gen) needSoname(need *Need, class elf.Class) {
+	providers := self.Soname(need.Name)
+	// 32-bit libraries are in lib32 on 64-bit systems
+	lib32 := make([]SonameProvider, 0)
+	other := make([]SonameProvider, 0)
+	for _, p := range providers {
+		if p.Resolved == "" {
+			continue
+		}
+		if strings.Contains(p.Path, "/lib32/") {
+			lib32 = append(lib32, p)
+		} else {
+			other = append(other, p)
+		}
+	}
+	if class == elf.ELFCLASS32 && len(lib32) != 0 {
+		providers = lib32
+	} else {
+		providers = other
+	}
+	if len(providers) == 0 {
+		return
+	}
+	need.Found = true
+	need.Path = providers[0].Path
+	for _, p := range providers {
+		if p.Path == need.Path {
+			need.Pkgvers = append(need.Pkgvers, p.Pkgver)
+		}
+	}
+}
+
+/*
+ * read the PT_INTERP and DT_NEEDED entries of an elf file and find them
+ * in the tree
+ */
+func (self *Gen) Needs(r io.ReaderAt) ([]Need, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	needs := make([]Need, 0)
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return nil, err
+		}
+		need := Need{Name: strings.TrimRight(string(data), "\x00"), Interp: true, Pkgvers: make([]Pkgver, 0)}
+		self.needPath(&need, need.Name)
+		needs = append(needs, need)
+	}
+
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return nil, err
+	}
+	for _, lib := range libs {
+		need := Need{Name: lib, Pkgvers: make([]Pkgver, 0)}
+		if strings.Contains(lib, "/") {
+			self.needPath(&need, lib)
+		} else {
+			self.needSoname(&need, f.Class)
+		}
+		needs = append(needs, need)
+	}
+
+	for _, need := range needs {
+		sort.Slice(need.Pkgvers, func(i1, i2 int) bool {
+			return need.Pkgvers[i1] < need.Pkgvers[i2]
+		})
+	}
+	return needs, nil
+}
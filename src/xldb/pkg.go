@@ -0,0 +1,83 @@
+/*
+ * looking at one package
+ */
+
+package xldb
+
+import (
+	"sort"
+)
+
+type PkgInfo struct {
+	Pkgname string
+	Version string
+	Pkgver  Pkgver
+	Types   VfsTypes // what it owns, counted when the generation was built
+}
+
+type PkgEntry struct {
+	Path string
+	Vfs  Vfs
+	Type VfsType
+}
+
+/*
+ * the current pkgver of pkgname, empty if there's no such package
+ */
+func (self *Gen) GetPkgver(pkgname string) Pkgver {
+	version, ok := self.pkgs[pkgname]
+	if !ok {
+		return ""
+	}
+	return JoinPkgver(pkgname, version)
+}
+
+/*
+ * every package, sorted by name
+ */
+func (self *Gen) Pkgs() []PkgInfo {
+	rv := make([]PkgInfo, 0, len(self.pkgs))
+	for pkgname, version := range self.pkgs {
+		info := PkgInfo{Pkgname: pkgname, Version: version, Pkgver: JoinPkgver(pkgname, version)}
+		if pkg, ok := self.pkgIds[info.Pkgver]; ok {
+			info.Types = self.pkgTypes[pkg]
+		}
+		rv = append(rv, info)
+	}
+	sort.Slice(rv, func(i1, i2 int) bool {
+		return rv[i1].Pkgname < rv[i2].Pkgname
+	})
+	return rv
+}
+
+/*
+ * every dir, file and link pkgver owns except the root, in preorder with
+ * the children of each dir sorted by name
+ */
+func (self *Gen) PkgEntries(pkgver Pkgver) []PkgEntry {
+	rv := make([]PkgEntry, 0)
+	pkg, ok := self.pkgIds[pkgver]
+	if !ok {
+		return rv
+	}
+	var walk func(vfs Vfs, path string)
+	walk = func(vfs Vfs, path string) {
+		names := make([]string, 0, len(self.nodes[vfs].children))
+		for name, cvfs := range self.nodes[vfs].children {
+			if self.vfsGetOwner(cvfs, pkg).Ok() {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			cvfs := self.nodes[vfs].children[name]
+			vtype := self.vfsGetOwner(cvfs, pkg)
+			rv = append(rv, PkgEntry{path + "/" + name, cvfs, vtype})
+			if vtype.IsDir() {
+				walk(cvfs, path+"/"+name)
+			}
+		}
+	}
+	walk(VFS_ROOT, "")
+	return rv
+}
@@ -0,0 +1,34 @@
+package xldb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type Progress struct {
+	Ready    bool // a load has finished and there's something to show
+	Loading  bool
+	Lines    int64 // lines read by the current or last load
+	Packages int64
+	Elapsed  time.Duration
+	Index    int // size of the path index in bytes, 0 if there isn't one
+}
+
+func (self *Xldb) GetProgress() Progress {
+	progress := Progress{
+		Ready:    self.Get().LastModified != "",
+		Loading:  atomic.LoadInt32(&self.loading) > 0,
+		Lines:    atomic.LoadInt64(&self.progress_lines),
+		Packages: atomic.LoadInt64(&self.progress_pkgs),
+		Index:    self.Get().IndexSize(),
+	}
+	start := atomic.LoadInt64(&self.progress_start)
+	end := atomic.LoadInt64(&self.progress_end)
+	if start != 0 {
+		if end == 0 {
+			end = time.Now().UnixNano()
+		}
+		progress.Elapsed = time.Duration(end - start)
+	}
+	return progress
+}
@@ -0,0 +1,266 @@
+/*
+ * finding paths by name, glob or regex
+ */
+
+package xldb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type SearchMode string
+
+const (
+	SEARCH_NAME      = SearchMode("name")      // exact basename
+	SEARCH_SUBSTRING = SearchMode("substring") // anywhere in the path
+	SEARCH_GLOB      = SearchMode("glob")      // shell glob, "*" also matches "/"
+	SEARCH_REGEX     = SearchMode("regex")     // RE2, unanchored
+)
+
+// longest pattern Search() accepts
+const SearchMaxPattern = 1024
+
+type SearchMatch struct {
+	Path string
+	Vfs  Vfs
+}
+
+type SearchResult struct {
+	Matches   []SearchMatch // sorted by path
+	Truncated bool          // stopped after limit matches
+	TimedOut  bool          // stopped after the timeout
+	Elapsed   time.Duration
+}
+
+// the posix classes globs can have in brackets, same as in RE2
+var globClasses = map[string]bool{
+	"alnum": true, "alpha": true, "blank": true, "cntrl": true,
+	"digit": true, "graph": true, "lower": true, "print": true,
+	"punct": true, "space": true, "upper": true, "xdigit": true,
+}
+
+/*
+ * convert a shell glob to an anchored regex
+ * the result can still fail to compile (like "[z-a]")
+ */
+func globToRegexp(glob string) (string, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '\\':
+			if i+1 == len(glob) {
+				return "", fmt.Errorf("trailing backslash in glob")
+			}
+			i++
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			// "]" right after "[" or "[!" is part of the class
+			j := i + 1
+			if j < len(glob) && (glob[j] == '!' || glob[j] == '^') {
+				j++
+			}
+			if j < len(glob) && glob[j] == ']' {
+				j++
+			}
+			for j < len(glob) && glob[j] != ']' {
+				// "[:alpha:]" and such, its "]" doesn't end the class
+				if strings.HasPrefix(glob[j:], "[:") {
+					end := strings.Index(glob[j+2:], ":]")
+					if end == -1 {
+						return "", fmt.Errorf("unterminated [: in glob")
+					}
+					name := glob[j+2 : j+2+end]
+					if !globClasses[name] {
+						return "", fmt.Errorf("unknown character class '[:%s:]' in glob", name)
+					}
+					j += 2 + end + 2
+					continue
+				}
+				j++
+			}
+			if j == len(glob) {
+				return "", fmt.Errorf("unterminated [ in glob")
+			}
+			class := glob[i+1 : j]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i = j
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return re.String(), nil
+}
+
+/*
+ * find paths matching pattern
+ *
+ * globs without a "/" are matched against the basename, everything else
+ * against the full path
+ *
+ * the path index is used to find candidates if there is one and the
+ * pattern has something to look up in it
+ *
+ * stops after limit matches or when timeout has passed, whichever is first
+ */
+func (self *Gen) Search(mode SearchMode, pattern string, limit int, timeout time.Duration) (*SearchResult, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	if len(pattern) > SearchMaxPattern {
+		return nil, fmt.Errorf("pattern is too long")
+	}
+
+	var re *regexp.Regexp
+	var trigrams []uint32
+	basename := false
+	switch mode {
+	case SEARCH_NAME:
+		basename = true
+		trigrams = appendTrigrams(nil, "/"+pattern+"/")
+	case SEARCH_SUBSTRING:
+		re = regexp.MustCompile(regexp.QuoteMeta(pattern))
+		trigrams = regexTrigrams(re.String())
+	case SEARCH_GLOB:
+		s, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if re, err = regexp.Compile(s); err != nil {
+			return nil, err
+		}
+		trigrams = regexTrigrams(s)
+		basename = !strings.Contains(pattern, "/")
+	case SEARCH_REGEX:
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+		trigrams = regexTrigrams(pattern)
+	default:
+		return nil, fmt.Errorf("unknown search mode '%s'", mode)
+	}
+
+	if self.index != nil {
+		trigrams = self.index.selectTrigrams(trigrams)
+	} else {
+		trigrams = nil
+	}
+
+	start := time.Now()
+	rv := &SearchResult{Matches: make([]SearchMatch, 0)}
+	matched := make([]Vfs, 0)
+	// how many nodes to check between looking at the clock
+	const checkEvery = 4096
+	checked := 0
+	stop := func() bool {
+		// one extra match to know if there were more
+		if len(matched) > limit {
+			return true
+		}
+		checked++
+		if checked%checkEvery == 0 && time.Since(start) > timeout {
+			rv.TimedOut = true
+			return true
+		}
+		return false
+	}
+
+	checkName := func(vfs Vfs) {
+		name := self.nodes[vfs].name
+		if re == nil && name == pattern || re != nil && re.MatchString(name) {
+			matched = append(matched, vfs)
+		}
+	}
+	// walk the tree so the path can be built up in one buffer
+	// children are visited by name so that it stops at the same place
+	// every time when there are too many matches
+	buf := make([]byte, 0, 256)
+	var walk func(vfs Vfs) bool
+	walk = func(vfs Vfs) bool {
+		children := self.nodes[vfs].children
+		names := make([]string, 0, len(children))
+		for name := range children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			cvfs := children[name]
+			if stop() {
+				return false
+			}
+			l := len(buf)
+			buf = append(buf, '/')
+			buf = append(buf, name...)
+			if re.Match(buf) {
+				matched = append(matched, cvfs)
+			}
+			if !walk(cvfs) {
+				return false
+			}
+			buf = buf[0:l]
+		}
+		return true
+	}
+
+	switch {
+	case len(trigrams) != 0 && basename:
+		for _, vfs := range self.indexNameCandidates(trigrams) {
+			if stop() {
+				break
+			}
+			checkName(vfs)
+		}
+	case len(trigrams) != 0:
+		for _, root := range self.indexPathCandidates(trigrams) {
+			if stop() {
+				break
+			}
+			buf = append(buf[0:0], self.VfsGetPath(root)...)
+			if re.Match(buf) {
+				matched = append(matched, root)
+			}
+			if !walk(root) {
+				break
+			}
+		}
+	case basename:
+		for i := range self.nodes {
+			vfs := Vfs(i)
+			if vfs == VFS_ROOT || self.nodes[vfs].parent == VFS_NONE {
+				continue
+			}
+			if stop() {
+				break
+			}
+			checkName(vfs)
+		}
+	default:
+		walk(VFS_ROOT)
+	}
+
+	if len(matched) > limit {
+		matched = matched[0:limit]
+		rv.Truncated = true
+	}
+	for _, vfs := range matched {
+		rv.Matches = append(rv.Matches, SearchMatch{self.VfsGetPath(vfs), vfs})
+	}
+	sort.Slice(rv.Matches, func(i1, i2 int) bool {
+		return rv.Matches[i1].Path < rv.Matches[i2].Path
+	})
+	rv.Elapsed = time.Since(start)
+	return rv, nil
+}
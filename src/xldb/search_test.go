@@ -0,0 +1,127 @@
+package xldb
+
+import (
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		want  string // empty if it should fail
+		match []string
+		not   []string
+	}{
+		{"*.so", `^.*\.so$`, []string{"libc.so", "/usr/lib/libc.so"}, []string{"libc.so.6"}},
+		{"lib?.a", `^lib.\.a$`, []string{"libc.a", "libm.a"}, []string{"libcc.a"}},
+		{`a\*b`, `^a\*b$`, []string{"a*b"}, []string{"axb"}},
+		{"[abc]", `^[abc]$`, []string{"a", "c"}, []string{"d"}},
+		{"[!abc]", `^[^abc]$`, []string{"d"}, []string{"a"}},
+		{"[^abc]", `^[^abc]$`, []string{"d"}, []string{"a"}},
+		{"[]]", `^[]]$`, []string{"]"}, []string{"a"}},
+		{"[!]]", `^[^]]$`, []string{"a"}, []string{"]"}},
+		{`[\]`, `^[\\]$`, []string{`\`}, []string{"a"}},
+		{"[[:alpha:]]*", `^[[:alpha:]].*$`, []string{"abc", "Z9"}, []string{"9abc"}},
+		{"[![:digit:]]", `^[^[:digit:]]$`, []string{"a"}, []string{"5"}},
+		{"[[:upper:][:digit:]_]", `^[[:upper:][:digit:]_]$`, []string{"A", "7", "_"}, []string{"a"}},
+		{"[a[]", `^[a[]$`, []string{"[", "a"}, []string{"b"}},
+		{"x.so.[0-9]", `^x\.so\.[0-9]$`, []string{"x.so.1"}, []string{"x.so.a"}},
+		{`trailing\`, "", nil, nil},
+		{"[abc", "", nil, nil},
+		{"[[:alpha:]", "", nil, nil},
+		{"[[:alpha]]", "", nil, nil},
+		{"[[:nope:]]", "", nil, nil},
+	}
+	for _, test := range tests {
+		got, err := globToRegexp(test.glob)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("globToRegexp(%q) = %q, expected an error", test.glob, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("globToRegexp(%q): %s", test.glob, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("globToRegexp(%q) = %q, want %q", test.glob, got, test.want)
+			continue
+		}
+		re, err := regexp.Compile(got)
+		if err != nil {
+			t.Errorf("globToRegexp(%q) = %q doesn't compile: %s", test.glob, got, err)
+			continue
+		}
+		for _, s := range test.match {
+			if !re.MatchString(s) {
+				t.Errorf("glob %q doesn't match %q", test.glob, s)
+			}
+		}
+		for _, s := range test.not {
+			if re.MatchString(s) {
+				t.Errorf("glob %q matches %q", test.glob, s)
+			}
+		}
+	}
+}
+
+func TestSearchBadGlob(t *testing.T) {
+	gen := testGen(t, testList)
+	for _, glob := range []string{"[z-a]*", "[[:nope:]]*", "[abc"} {
+		if _, err := gen.Search(SEARCH_GLOB, glob, 100, time.Second); err == nil {
+			t.Errorf("Search(%q) didn't fail", glob)
+		}
+	}
+	result, err := gen.Search(SEARCH_GLOB, "[[:alpha:]]*.so.[[:digit:]]", 100, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Matches) != 5 {
+		t.Errorf("got %d matches, want 5: %v", len(result.Matches), result.Matches)
+	}
+}
+
+func TestSearchTruncatedStable(t *testing.T) {
+	gen := testGen(t, indexTestList())
+	xd := &Xldb{PathIndex: true, Log: ioutil.Discard}
+	xd.indexGen(gen)
+	index := gen.index
+
+	tests := []struct {
+		mode    SearchMode
+		pattern string
+	}{
+		{SEARCH_SUBSTRING, "/data/"},
+		{SEARCH_GLOB, "*.txt"},
+		{SEARCH_GLOB, "/usr/share/*"},
+		{SEARCH_REGEX, `pkg1`},
+		{SEARCH_REGEX, `file[0-9]`},
+	}
+	for _, test := range tests {
+		for _, idx := range []*pathIndex{nil, index} {
+			gen.index = idx
+			var first []string
+			for i := 0; i < 20; i++ {
+				result, err := gen.Search(test.mode, test.pattern, 7, time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Truncated {
+					t.Fatalf("%s %q: wasn't truncated", test.mode, test.pattern)
+				}
+				paths := matchPaths(result)
+				if first == nil {
+					first = paths
+				} else if !reflect.DeepEqual(paths, first) {
+					t.Errorf("%s %q (index %t): got %v, then %v",
+						test.mode, test.pattern, idx != nil, first, paths)
+					break
+				}
+			}
+		}
+	}
+}
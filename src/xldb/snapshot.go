@@ -0,0 +1,295 @@
+/*
+ * saving and restoring a loaded generation so startup doesn't need a full load
+ *
+ * format (integers are uvarints, strings are a length and the bytes):
+ *
+ *   magic, version
+ *   commit, last-modified
+ *   number of pkgvers, pkgvers
+ *   the tree in preorder, starting with the root:
+ *     name, number of owners, owners, number of children, children
+ *     owner: pkgver index, type (0 dir, 1 file, 2 link), link target
+ *   crc32 (ieee, little endian) of everything before it
+ */
+
+package xldb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+const snapshotMagic = "voidfs-snapshot\n"
+const snapshotVersion = 1
+
+// sanity limits so a corrupt file can't make us allocate too much
+const snapshotMaxString = 1 << 16
+const snapshotMaxCount = 1 << 26
+
+const (
+	snapshotDir  = 0
+	snapshotFile = 1
+	snapshotLink = 2
+)
+
+var errSnapshotStale = errors.New("snapshot is out of date")
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (self *snapshotWriter) write(p []byte) {
+	if self.err != nil {
+		return
+	}
+	self.crc.Write(p)
+	_, self.err = self.w.Write(p)
+}
+
+func (self *snapshotWriter) uint(n uint64) {
+	self.write(self.buf[0:binary.PutUvarint(self.buf[:], n)])
+}
+
+func (self *snapshotWriter) string(s string) {
+	self.uint(uint64(len(s)))
+	self.write([]byte(s))
+}
+
+/*
+ * write gen to path, replacing it atomically
+ */
+func writeSnapshot(gen *Gen, path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// unique name so the server and a cli run can't write the same one
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	defer f.Close()
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+
+	sw := &snapshotWriter{w: bufio.NewWriterSize(f, 1<<20), crc: crc32.NewIEEE()}
+	sw.write([]byte(snapshotMagic))
+	sw.uint(snapshotVersion)
+	sw.string(gen.Commit)
+	sw.string(gen.LastModified)
+
+	pkgs := make([]pkgId, 0, len(gen.pkgIds))
+	for _, pkg := range gen.pkgIds {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i1, i2 int) bool {
+		return gen.pkgvers[pkgs[i1]] < gen.pkgvers[pkgs[i2]]
+	})
+	pkgindex := make(map[pkgId]uint64, len(pkgs))
+	sw.uint(uint64(len(pkgs)))
+	for i, pkg := range pkgs {
+		pkgindex[pkg] = uint64(i)
+		sw.string(string(gen.pkgvers[pkg]))
+	}
+
+	var writeVfs func(vfs Vfs, name string)
+	writeVfs = func(vfs Vfs, name string) {
+		sw.string(name)
+		node := &gen.nodes[vfs]
+		sw.uint(uint64(len(node.owners)))
+		for _, owner := range node.owners {
+			sw.uint(pkgindex[owner.pkg])
+			switch owner.vtype {
+			case vtypeDir:
+				sw.uint(snapshotDir)
+			case vtypeFile:
+				sw.uint(snapshotFile)
+			default:
+				sw.uint(snapshotLink)
+				sw.string(gen.vtypes[owner.vtype].GetTarget())
+			}
+		}
+		sw.uint(uint64(len(node.children)))
+		for cname, cvfs := range node.children {
+			writeVfs(cvfs, cname)
+		}
+	}
+	writeVfs(VFS_ROOT, "")
+
+	if sw.err != nil {
+		return sw.err
+	}
+	if err := binary.Write(sw.w, binary.LittleEndian, sw.crc.Sum32()); err != nil {
+		return err
+	}
+	if err := sw.w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (self *snapshotReader) ReadByte() (byte, error) {
+	c, err := self.r.ReadByte()
+	if err == nil {
+		self.crc.Write([]byte{c})
+	}
+	return c, err
+}
+
+func (self *snapshotReader) uint(max uint64) uint64 {
+	if self.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(self)
+	if err != nil {
+		self.err = err
+		return 0
+	}
+	if n > max {
+		self.err = fmt.Errorf("snapshot is corrupt")
+		return 0
+	}
+	return n
+}
+
+func (self *snapshotReader) string() string {
+	n := self.uint(snapshotMaxString)
+	if self.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(self.r, buf); err != nil {
+		self.err = err
+		return ""
+	}
+	self.crc.Write(buf)
+	return string(buf)
+}
+
+/*
+ * read the snapshot at path if it's for the given commit and date
+ */
+func readSnapshot(path string, commit string, lastModified string, pkgs *int64) (*Gen, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sr := &snapshotReader{r: bufio.NewReaderSize(f, 1<<20), crc: crc32.NewIEEE()}
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, fmt.Errorf("not a snapshot file")
+	}
+	sr.crc.Write(magic)
+	if version := sr.uint(snapshotMaxCount); sr.err == nil && version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	if sr.string() != commit || sr.string() != lastModified {
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		return nil, errSnapshotStale
+	}
+
+	gen := newGen()
+	b := gen.newBuilder()
+	ids := make([]pkgId, sr.uint(snapshotMaxCount))
+	for i := range ids {
+		pkgver := Pkgver(sr.string())
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		if !isValidPkgver(string(pkgver)) {
+			return nil, fmt.Errorf("snapshot is corrupt")
+		}
+		ids[i], _ = gen.pkgverId(pkgver)
+		// each node lists an owner once so there's nothing to look for
+		b.fresh[ids[i]] = true
+		pkgname, version := pkgver.Split()
+		gen.pkgs[pkgname] = version
+		atomic.AddInt64(pkgs, 1)
+	}
+
+	var readVfs func(vfs Vfs)
+	readVfs = func(vfs Vfs) {
+		for n := sr.uint(snapshotMaxCount); n > 0 && sr.err == nil; n-- {
+			i := sr.uint(uint64(len(ids)))
+			if sr.err == nil && i == uint64(len(ids)) {
+				sr.err = fmt.Errorf("snapshot is corrupt")
+			}
+			if sr.err != nil {
+				return
+			}
+			var vt vtypeId
+			switch sr.uint(snapshotLink) {
+			case snapshotDir:
+				vt = vtypeDir
+			case snapshotFile:
+				vt = vtypeFile
+			case snapshotLink:
+				target := sr.string()
+				if target == "" && sr.err == nil {
+					sr.err = fmt.Errorf("snapshot is corrupt")
+				}
+				vt = gen.vfsTypeId(VfsType(target))
+			}
+			gen.vfsSetOwner(b, vfs, ids[i], vt)
+		}
+		for n := sr.uint(snapshotMaxCount); n > 0 && sr.err == nil; n-- {
+			name := sr.string()
+			if sr.err != nil {
+				return
+			}
+			readVfs(gen.vfsGetOrCreate(b, vfs, name))
+		}
+	}
+	if sr.string() != "" {
+		sr.err = fmt.Errorf("snapshot is corrupt")
+	}
+	readVfs(VFS_ROOT)
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	gen.compact()
+
+	sum := sr.crc.Sum32()
+	var want uint32
+	if err := binary.Read(sr.r, binary.LittleEndian, &want); err != nil {
+		return nil, err
+	}
+	if sum != want {
+		return nil, fmt.Errorf("snapshot checksum mismatch")
+	}
+
+	gen.Commit = commit
+	gen.LastModified = lastModified
+	return gen, nil
+}
@@ -0,0 +1,40 @@
+package xldb
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	gen := testGen(t, testList)
+	gen.Commit = "0123456789abcdef0123456789abcdef01234567"
+	gen.LastModified = "Sat, 03 Apr 2021 06:00:00 GMT"
+
+	path := filepath.Join(t.TempDir(), "sub", "voidfs.snapshot")
+	if err := writeSnapshot(gen, path); err != nil {
+		t.Fatal(err)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); len(tmps) != 0 {
+		t.Errorf("temporary files left behind: %v", tmps)
+	}
+
+	var pkgs int64
+	read, err := readSnapshot(path, gen.Commit, gen.LastModified, &pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Commit != gen.Commit || read.LastModified != gen.LastModified {
+		t.Errorf("got commit %q, last-modified %q", read.Commit, read.LastModified)
+	}
+	if got, want := dumpGen(read), dumpGen(gen); got != want {
+		t.Errorf("tree differs after reading the snapshot\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := read.Pkgs(), gen.Pkgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Pkgs() differs after reading the snapshot\ngot:  %v\nwant: %v", got, want)
+	}
+
+	if _, err := readSnapshot(path, "fedcba9876543210fedcba9876543210fedcba98", gen.LastModified, &pkgs); err != errSnapshotStale {
+		t.Errorf("reading it for another commit: got %v, want %v", err, errSnapshotStale)
+	}
+}
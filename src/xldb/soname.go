@@ -0,0 +1,126 @@
+/*
+ * index of shared libraries in the library dirs
+ */
+
+package xldb
+
+import (
+	"sort"
+	"strings"
+)
+
+// where shared libraries are looked for
+var LibraryDirs = []string{"/usr/lib", "/usr/lib32", "/usr/lib64", "/lib", "/lib32", "/lib64"}
+
+type SonameProvider struct {
+	Path     string
+	Pkgver   Pkgver
+	Type     VfsType
+	Resolved string // the file a link ends up at, empty if it's dangling
+}
+
+func isSoname(name string) bool {
+	return strings.HasPrefix(name, "lib") &&
+		(strings.HasSuffix(name, ".so") || strings.Contains(name, ".so."))
+}
+
+/*
+ * follow a link until something that isn't one
+ */
+func (self *Gen) vfsResolveLink(vfs Vfs, target string, depth int) Vfs {
+	for ; depth > 0; depth-- {
+		vfs = self.VfsLinkResolveTarget(vfs, target)
+		if vfs == VFS_NONE {
+			return VFS_NONE
+		}
+		target = ""
+		for _, owner := range self.nodes[vfs].owners {
+			if owner.vtype == vtypeDir || owner.vtype == vtypeFile {
+				return vfs
+			}
+			if target == "" {
+				target = self.vtypes[owner.vtype].GetTarget()
+			}
+		}
+	}
+	return VFS_NONE
+}
+
+func (self *Gen) buildSonames() map[string][]SonameProvider {
+	sonames := make(map[string][]SonameProvider)
+	seen := make(map[Vfs]bool)
+	for _, path := range LibraryDirs {
+		dir := self.VfsDirFollowPath(VFS_ROOT, path)
+		if dir == VFS_NONE {
+			continue
+		}
+		if dir = self.VfsDirResolve(dir, 3); dir == VFS_NONE || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		for name, cvfs := range self.nodes[dir].children {
+			if !isSoname(name) {
+				continue
+			}
+			cpath := self.VfsGetPath(cvfs)
+			for _, owner := range self.VfsGetOwners(cvfs) {
+				provider := SonameProvider{Path: cpath, Pkgver: owner.Pkgver, Type: owner.Type}
+				switch {
+				case owner.Type.IsDir():
+					continue
+				case owner.Type.IsFile():
+					provider.Resolved = cpath
+				default:
+					if tgt := self.vfsResolveLink(cvfs, owner.Type.GetTarget(), 8); tgt != VFS_NONE {
+						provider.Resolved = self.VfsGetPath(tgt)
+					}
+				}
+				sonames[name] = append(sonames[name], provider)
+			}
+		}
+	}
+	for _, providers := range sonames {
+		sort.Slice(providers, func(i1, i2 int) bool {
+			p1, p2 := providers[i1], providers[i2]
+			if p1.Path != p2.Path {
+				return p1.Path < p2.Path
+			}
+			return p1.Pkgver < p2.Pkgver
+		})
+	}
+	return sonames
+}
+
+/*
+ * the index is built the first time it's needed
+ */
+func (self *Gen) getSonames() map[string][]SonameProvider {
+	self.sonamesOnce.Do(func() {
+		self.sonames = self.buildSonames()
+	})
+	return self.sonames
+}
+
+/*
+ * the files and links named name in the library dirs and their owners
+ */
+func (self *Gen) Soname(name string) []SonameProvider {
+	return self.getSonames()[name]
+}
+
+/*
+ * sonames where more than one package has the same path
+ */
+func (self *Gen) SonameConflicts() map[string][]SonameProvider {
+	rv := make(map[string][]SonameProvider)
+	for name, providers := range self.getSonames() {
+		for i := 1; i < len(providers); i++ {
+			if providers[i].Path == providers[i-1].Path &&
+				providers[i].Pkgver.Name() != providers[i-1].Pkgver.Name() {
+				rv[name] = providers
+				break
+			}
+		}
+	}
+	return rv
+}
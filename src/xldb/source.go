@@ -0,0 +1,163 @@
+/*
+ * sources of "pkgver,path[ -> target]" entries for Xldb.load()
+ */
+
+package xldb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+import "gitobj"
+
+type lineSource interface {
+	Scan() bool
+	Pkgver() string
+	Line() string // "path[ -> target]"
+	Err() error
+}
+
+func isValidPkgver(pkgver string) bool {
+	// needs a dash for Pkgver.Split()
+	return strings.LastIndex(pkgver, "-") > 0
+}
+
+/*
+ * reads a plain text file list
+ */
+type listScanner struct {
+	scanner *bufio.Scanner
+	log     io.Writer // for complaining about bad lines
+	lineno  int
+	pkgver  string
+	line    string
+}
+
+func newListScanner(r io.Reader, log io.Writer) *listScanner {
+	return &listScanner{scanner: bufio.NewScanner(r), log: log}
+}
+
+func (self *listScanner) Scan() bool {
+	for self.scanner.Scan() {
+		line := self.scanner.Text()
+		self.lineno += 1
+		if line == "" {
+			continue
+		}
+		if comma := strings.Index(line, thecomma); comma == -1 || !isValidPkgver(line[0:comma]) {
+			fmt.Fprintf(self.log, "xldb: line %d: invalid line '%s'\n", self.lineno, line)
+			continue
+		}
+		self.pkgver, self.line = splitLine(line)
+		return true
+	}
+	return false
+}
+
+func (self *listScanner) Pkgver() string { return self.pkgver }
+func (self *listScanner) Line() string   { return self.line }
+func (self *listScanner) Err() error     { return self.scanner.Err() }
+
+/*
+ * reads the per-package blobs in a tree of the xlocate repo
+ * (one file per pkgver, one line per path)
+ */
+type treeScanner struct {
+	repo  *gitobj.Repo
+	blobs []gitobj.TreeEntry
+	log   io.Writer // for complaining about bad names
+	data  []byte
+	prev  string
+
+	pkgver string
+	line   string
+	err    error
+}
+
+func newTreeScanner(repo *gitobj.Repo, tree gitobj.Hash, log io.Writer) (*treeScanner, error) {
+	blobs, err := listTree(repo, tree, "")
+	if err != nil {
+		return nil, err
+	}
+	return &treeScanner{repo: repo, blobs: blobs, log: log}, nil
+}
+
+/*
+ * list the blobs in a tree and its subtrees
+ * the names of blobs in subtrees are prefixed with the subtree name and "/"
+ */
+func listTree(repo *gitobj.Repo, tree gitobj.Hash, prefix string) ([]gitobj.TreeEntry, error) {
+	entries, err := repo.ReadTree(tree)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]gitobj.TreeEntry, 0, len(entries))
+	for _, entry := range entries {
+		entry.Name = prefix + entry.Name
+		if entry.IsTree() {
+			sub, err := listTree(repo, entry.Hash, entry.Name+"/")
+			if err != nil {
+				return nil, err
+			}
+			blobs = append(blobs, sub...)
+		} else {
+			blobs = append(blobs, entry)
+		}
+	}
+	return blobs, nil
+}
+
+func (self *treeScanner) nextBlob() bool {
+	for len(self.blobs) > 0 {
+		entry := self.blobs[0]
+		self.blobs = self.blobs[1:]
+		if !isValidPkgver(entry.Name) {
+			fmt.Fprintf(self.log, "xldb: invalid package name '%s'\n", entry.Name)
+			continue
+		}
+		data, err := self.repo.ReadBlob(entry.Hash)
+		if err != nil {
+			self.err = err
+			return false
+		}
+		self.pkgver = entry.Name
+		self.data = data
+		self.prev = ""
+		return true
+	}
+	return false
+}
+
+func (self *treeScanner) Scan() bool {
+	for {
+		for len(self.data) > 0 {
+			nl := bytes.IndexByte(self.data, '\n')
+			if nl == -1 {
+				nl = len(self.data)
+			}
+			line := self.data[0:nl]
+			self.data = self.data[nl:]
+			if len(self.data) > 0 {
+				self.data = self.data[1:]
+			}
+			// skip empty and repeated lines (like uniq)
+			if len(line) == 0 || string(line) == self.prev {
+				continue
+			}
+			self.line = string(line)
+			self.prev = self.line
+			return true
+		}
+		if !self.nextBlob() {
+			return false
+		}
+	}
+}
+
+func (self *treeScanner) Pkgver() string { return self.pkgver }
+func (self *treeScanner) Line() string   { return self.line }
+func (self *treeScanner) Err() error     { return self.err }
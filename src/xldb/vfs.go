@@ -1,6 +1,6 @@
 /*
  * vfs methods that don't modify the database
- * all of these expect that you've called xldb.RLock() first
+ * a published Gen never changes so these don't need any locking
  */
 
 package xldb
@@ -12,31 +12,34 @@ import (
 	"sync"
 )
 
-func (self *Xldb) VfsCd(vfs *Vfs, name string) *Vfs {
+func (self *Gen) VfsCd(vfs Vfs, name string) Vfs {
 	switch name {
 	case ".":
 		return vfs
 	case "..":
 		return self.VfsGetParent(vfs)
 	default:
-		return (*vfs)[name]
+		if cvfs, ok := self.nodes[vfs].children[name]; ok {
+			return cvfs
+		}
+		return VFS_NONE
 	}
 }
 
-func (self *Xldb) vfsckCountTypesTotal(vfs *Vfs, pkgver Pkgver, total *VfsTypes) {
-	vtype := self.vfs_owners[vfs][pkgver]
+func (self *Gen) vfsckCountTypesTotal(vfs Vfs, pkg pkgId, total *VfsTypes) {
+	vtype := self.vfsGetOwner(vfs, pkg)
 	if !vtype.Ok() {
 		fmt.Printf("vfsck_count_types_total: '%s' does not own vfs '%s'!\n",
-			pkgver, self.VfsGetPath(vfs))
+			self.pkgvers[pkg], self.VfsGetPath(vfs))
 		return
 	}
 	switch vtype {
 	case XLDB_DIR:
 		total.Dir += 1
-		for _, cvfs := range *vfs {
-			cvtype := self.vfs_owners[cvfs][pkgver]
+		for _, cvfs := range self.nodes[vfs].children {
+			cvtype := self.vfsGetOwner(cvfs, pkg)
 			if cvtype.Ok() {
-				self.vfsckCountTypesTotal(cvfs, pkgver, total)
+				self.vfsckCountTypesTotal(cvfs, pkg, total)
 			}
 		}
 	case XLDB_FILE:
@@ -46,19 +49,48 @@ func (self *Xldb) vfsckCountTypesTotal(vfs *Vfs, pkgver Pkgver, total *VfsTypes)
 	}
 }
 
-func (self *Xldb) Vfsck(vfs *Vfs) {
-	if vfs == nil {
-		vfs = &self.vfs_root
-		fmt.Println("vfsck: passed nil, defaulting to root (you should only see this once)")
+/*
+ * the type of vfs in pkgver, empty if it doesn't own it
+ */
+func (self *Gen) VfsGetPkgType(vfs Vfs, pkgver Pkgver) VfsType {
+	pkg, ok := self.pkgIds[pkgver]
+	if !ok {
+		return ""
+	}
+	return self.vfsGetOwner(vfs, pkg)
+}
+
+/*
+ * count what pkgver owns at and below vfs
+ */
+func (self *Gen) VfsCountPkgTypes(vfs Vfs, pkgver Pkgver) VfsTypes {
+	total := VfsTypes{}
+	if pkg, ok := self.pkgIds[pkgver]; ok && self.vfsGetOwner(vfs, pkg).Ok() {
+		self.vfsckCountTypesTotal(vfs, pkg, &total)
+	}
+	return total
+}
+
+func (self *Gen) Vfsck(vfs Vfs) {
+	if vfs == VFS_NONE {
+		vfs = VFS_ROOT
+		fmt.Println("vfsck: passed VFS_NONE, defaulting to root (you should only see this once)")
 		fmt.Println("vfsck: doing one-time checks")
 		// check that every pkgver in self.pkgs owns at least one dir and file/link
 		wg := sync.WaitGroup{}
 		wg.Add(len(self.pkgs))
 		for pkgname, version := range self.pkgs {
 			go func(pkgname, version string) {
+				defer wg.Done()
 				types := VfsTypes{}
 				pkgver := JoinPkgver(pkgname, version)
-				self.vfsckCountTypesTotal(vfs, pkgver, &types)
+				pkg, ok := self.pkgIds[pkgver]
+				if !ok {
+					fmt.Printf("vfsck: '%s' is in self.pkgs but not in the package table\n",
+						pkgver)
+					return
+				}
+				self.vfsckCountTypesTotal(vfs, pkg, &types)
 				if types.File == 0 && types.Link == 0 {
 					fmt.Printf("vfsck: '%s' doesn't own any files or links\n",
 						pkgver)
@@ -67,7 +99,6 @@ func (self *Xldb) Vfsck(vfs *Vfs) {
 					fmt.Printf("vfsck: '%s' doesn't own any directories\n",
 						pkgver)
 				}
-				wg.Done()
 			}(pkgname, version)
 		}
 		wg.Wait()
@@ -75,25 +106,26 @@ func (self *Xldb) Vfsck(vfs *Vfs) {
 		defer fmt.Println("vfsck: done")
 	}
 	// check that it has a parent
-	if self.vfs_parents[vfs] == nil {
+	if self.nodes[vfs].parent == VFS_NONE {
 		fmt.Printf("vfsck: vfs '%s' doesn't have a parent!\n",
 			self.VfsGetPath(vfs))
 	}
 	// check that it has owners
-	if self.vfs_owners[vfs] == nil || len(self.vfs_owners[vfs]) == 0 {
+	if len(self.nodes[vfs].owners) == 0 {
 		fmt.Printf("vfsck: vfs '%s' has no owners\n",
 			self.VfsGetPath(vfs))
 	}
 	// check that only root is its own parent
-	if (vfs == self.vfs_parents[vfs]) != (vfs == &self.vfs_root) {
-		if vfs == &self.vfs_root {
+	if (vfs == self.nodes[vfs].parent) != (vfs == VFS_ROOT) {
+		if vfs == VFS_ROOT {
 			fmt.Printf("vfsck: vfs_root is NOT its own parent\n")
 		} else {
 			fmt.Printf("vfsck: non-root vfs '%s' is its own parent\n",
 				self.VfsGetPath(vfs))
 		}
 	}
-	for pkgver, vtype := range self.vfs_owners[vfs] {
+	for _, owner := range self.nodes[vfs].owners {
+		pkgver, vtype := self.pkgvers[owner.pkg], self.vtypes[owner.vtype]
 		pkgname, version := pkgver.Split()
 		// check that the owner is the same version as in self.pkgs
 		//
@@ -104,8 +136,8 @@ func (self *Xldb) Vfsck(vfs *Vfs) {
 				self.VfsGetPath(vfs), pkgver, self.pkgs[pkgname])
 		}
 		// check that the parent is a directory in the same package
-		if parent := self.vfs_parents[vfs]; parent != nil {
-			pvtype := self.vfs_owners[parent][pkgver]
+		if parent := self.nodes[vfs].parent; parent != VFS_NONE {
+			pvtype := self.vfsGetOwner(parent, owner.pkg)
 			if !pvtype.IsDir() {
 				fmt.Printf("vfsck: parent of '%s' owned by '%s' is not a dir in that package\n",
 					self.VfsGetPath(vfs), pkgver)
@@ -114,8 +146,8 @@ func (self *Xldb) Vfsck(vfs *Vfs) {
 		// check that a dir has at least one child from the package,
 		// and a file/link has none
 		hasChild := false
-		for _, cvfs := range *vfs {
-			if self.vfs_owners[cvfs][pkgver].Ok() {
+		for _, cvfs := range self.nodes[vfs].children {
+			if self.vfsGetOwner(cvfs, owner.pkg).Ok() {
 				hasChild = true
 				break
 			}
@@ -131,9 +163,9 @@ func (self *Xldb) Vfsck(vfs *Vfs) {
 		}
 	}
 	wg := sync.WaitGroup{}
-	wg.Add(len(*vfs))
-	for _, cvfs := range *vfs {
-		go func(cvfs *Vfs) {
+	wg.Add(len(self.nodes[vfs].children))
+	for _, cvfs := range self.nodes[vfs].children {
+		go func(cvfs Vfs) {
 			self.Vfsck(cvfs)
 			wg.Done()
 		}(cvfs)
@@ -141,20 +173,20 @@ func (self *Xldb) Vfsck(vfs *Vfs) {
 	wg.Wait()
 }
 
-func (self *Xldb) VfsDirFollowPath(vfs *Vfs, path string) *Vfs {
-	if vfs == nil || strings.HasPrefix(path, "/") {
-		vfs = &self.vfs_root
+func (self *Gen) VfsDirFollowPath(vfs Vfs, path string) Vfs {
+	if vfs == VFS_NONE || strings.HasPrefix(path, "/") {
+		vfs = VFS_ROOT
 	}
 	for _, name := range splitPath(path) {
 		vfs = self.VfsCd(vfs, name)
-		if vfs == nil {
+		if vfs == VFS_NONE {
 			break
 		}
 	}
 	return vfs
 }
 
-func (self *Xldb) VfsGetDirslash(vfs *Vfs, depth int) string {
+func (self *Gen) VfsGetDirslash(vfs Vfs, depth int) string {
 	if self.VfsIsDir(vfs, depth) {
 		return "/"
 	} else {
@@ -162,33 +194,37 @@ func (self *Xldb) VfsGetDirslash(vfs *Vfs, depth int) string {
 	}
 }
 
-func (self *Xldb) VfsGetName(vfs *Vfs) string {
-	if vfs == &self.vfs_root {
-		return ""
-	}
-	for name, cvfs := range *self.VfsGetParent(vfs) {
-		if cvfs == vfs {
-			return name
-		}
-	}
-	return ""
+/*
+ * the children of a dir by name
+ * the map belongs to the generation and must not be modified
+ */
+func (self *Gen) VfsGetChildren(vfs Vfs) map[string]Vfs {
+	return self.nodes[vfs].children
 }
 
-func (self *Xldb) VfsGetOwners(vfs *Vfs) map[Pkgver]VfsType {
-	return self.vfs_owners[vfs]
+func (self *Gen) VfsGetName(vfs Vfs) string {
+	return self.nodes[vfs].name
+}
+
+/*
+ * the packages that own vfs and its type in each
+ */
+func (self *Gen) VfsGetOwners(vfs Vfs) []VfsOwner {
+	owners := make([]VfsOwner, len(self.nodes[vfs].owners))
+	for i, owner := range self.nodes[vfs].owners {
+		owners[i] = VfsOwner{self.pkgvers[owner.pkg], self.vtypes[owner.vtype]}
+	}
+	return owners
 }
 
-func (self *Xldb) VfsGetParent(vfs *Vfs) *Vfs {
-	return self.vfs_parents[vfs]
+func (self *Gen) VfsGetParent(vfs Vfs) Vfs {
+	return self.nodes[vfs].parent
 }
 
-func (self *Xldb) VfsGetPath(vfs *Vfs) string {
+func (self *Gen) VfsGetPath(vfs Vfs) string {
 	var path, slash string
-	for {
+	for vfs != VFS_ROOT && vfs != VFS_NONE {
 		name := self.VfsGetName(vfs)
-		if name == "" {
-			break
-		}
 		path = name + slash + path
 		slash = "/"
 		vfs = self.VfsGetParent(vfs)
@@ -199,13 +235,10 @@ func (self *Xldb) VfsGetPath(vfs *Vfs) string {
 /*
  * like VfsGetPath but url-encodes the path segments
  */
-func (self *Xldb) VfsGetPathUrlencoded(vfs *Vfs) string {
+func (self *Gen) VfsGetPathUrlencoded(vfs Vfs) string {
 	var path, slash string
-	for {
+	for vfs != VFS_ROOT && vfs != VFS_NONE {
 		name := self.VfsGetName(vfs)
-		if name == "" {
-			break
-		}
 		path = url.PathEscape(name) + slash + path
 		slash = "/"
 		vfs = self.VfsGetParent(vfs)
@@ -219,13 +252,13 @@ type VfsTypes struct {
 	Link int
 }
 
-func (self *Xldb) VfsGetTypes(vfs *Vfs) VfsTypes {
+func (self *Gen) VfsGetTypes(vfs Vfs) VfsTypes {
 	types := VfsTypes{}
-	for _, vtype := range self.vfs_owners[vfs] {
-		switch vtype {
-		case XLDB_DIR:
+	for _, owner := range self.nodes[vfs].owners {
+		switch owner.vtype {
+		case vtypeDir:
 			types.Dir += 1
-		case XLDB_FILE:
+		case vtypeFile:
 			types.File += 1
 		default:
 			types.Link += 1
@@ -234,21 +267,21 @@ func (self *Xldb) VfsGetTypes(vfs *Vfs) VfsTypes {
 	return types
 }
 
-func (self *Xldb) VfsIsDir(vfs *Vfs, depth int) bool {
+func (self *Gen) VfsIsDir(vfs Vfs, depth int) bool {
 	targets := make([]string, 0)
-	for _, vtype := range self.VfsGetOwners(vfs) {
-		if vtype.IsDir() {
+	for _, owner := range self.nodes[vfs].owners {
+		if owner.vtype == vtypeDir {
 			return true
 		}
-		if depth > 0 && vtype.IsLink() {
-			targets = append(targets, vtype.GetTarget())
+		if depth > 0 && owner.vtype != vtypeFile {
+			targets = append(targets, self.vtypes[owner.vtype].GetTarget())
 		}
 	}
 	if depth > 0 && len(targets) != 0 {
 		depth -= 1
 		for _, target := range targets {
 			tgt := self.VfsLinkResolveTarget(vfs, target)
-			if tgt != nil && self.VfsIsDir(tgt, depth) {
+			if tgt != VFS_NONE && self.VfsIsDir(tgt, depth) {
 				return true
 			}
 		}
@@ -256,6 +289,70 @@ func (self *Xldb) VfsIsDir(vfs *Vfs, depth int) bool {
 	return false
 }
 
-func (self *Xldb) VfsLinkResolveTarget(vfs *Vfs, target string) *Vfs {
+/*
+ * like VfsIsDir but returns the dir vfs is or links to (VFS_NONE if neither)
+ */
+func (self *Gen) VfsDirResolve(vfs Vfs, depth int) Vfs {
+	targets := make([]string, 0)
+	for _, owner := range self.nodes[vfs].owners {
+		if owner.vtype == vtypeDir {
+			return vfs
+		}
+		if depth > 0 && owner.vtype != vtypeFile {
+			targets = append(targets, self.vtypes[owner.vtype].GetTarget())
+		}
+	}
+	if depth > 0 && len(targets) != 0 {
+		depth -= 1
+		for _, target := range targets {
+			tgt := self.VfsLinkResolveTarget(vfs, target)
+			if tgt != VFS_NONE {
+				if dir := self.VfsDirResolve(tgt, depth); dir != VFS_NONE {
+					return dir
+				}
+			}
+		}
+	}
+	return VFS_NONE
+}
+
+/*
+ * follow path from the root, following links to dirs along the way but not
+ * the last component (like lstat)
+ */
+func (self *Gen) VfsLookupPath(path string) Vfs {
+	vfs := VFS_ROOT
+	for _, name := range splitPath(path) {
+		if vfs = self.VfsDirResolve(vfs, 8); vfs == VFS_NONE {
+			return VFS_NONE
+		}
+		if vfs = self.VfsCd(vfs, name); vfs == VFS_NONE {
+			return VFS_NONE
+		}
+	}
+	return vfs
+}
+
+/*
+ * like VfsLookupPath but also follows the last component if it's a link
+ * (like stat), VFS_NONE if it's dangling
+ */
+func (self *Gen) VfsResolvePath(path string) Vfs {
+	vfs := self.VfsLookupPath(path)
+	if vfs == VFS_NONE {
+		return VFS_NONE
+	}
+	for _, owner := range self.nodes[vfs].owners {
+		if owner.vtype == vtypeDir || owner.vtype == vtypeFile {
+			return vfs
+		}
+	}
+	if len(self.nodes[vfs].owners) != 0 {
+		return self.vfsResolveLink(vfs, self.vtypes[self.nodes[vfs].owners[0].vtype].GetTarget(), 8)
+	}
+	return vfs
+}
+
+func (self *Gen) VfsLinkResolveTarget(vfs Vfs, target string) Vfs {
 	return self.VfsDirFollowPath(self.VfsGetParent(vfs), target)
 }
@@ -0,0 +1,280 @@
+/*
+ * storage for the tree
+ *
+ * nodes live in one slice per generation and refer to each other by their
+ * index, which is a lot smaller than the maps of pointers this used to be
+ *
+ * owners refer to entries in a table of pkgvers and a table of types (dir,
+ * file or a link target) instead of having their own copies of the strings
+ *
+ * the methods here modify the tree so they're only used while building a
+ * generation that isn't published yet
+ */
+
+package xldb
+
+// a node in the tree (an index into Gen.nodes)
+type Vfs int32
+
+const VFS_ROOT = Vfs(0)
+const VFS_NONE = Vfs(-1)
+
+type VfsOwner struct {
+	Pkgver Pkgver
+	Type   VfsType
+}
+
+// an index into Gen.pkgvers
+type pkgId int32
+
+// an index into Gen.vtypes
+type vtypeId int32
+
+// the first two entries of Gen.vtypes, the rest are link targets
+const (
+	vtypeDir  = vtypeId(0)
+	vtypeFile = vtypeId(1)
+)
+
+type vfsOwner struct {
+	pkg   pkgId
+	vtype vtypeId
+}
+
+type vfsNode struct {
+	name     string         // empty for the root
+	parent   Vfs            // VFS_NONE if the node was removed
+	children map[string]Vfs // nil if there aren't any
+	owners   []vfsOwner
+}
+
+/*
+ * state that's only needed while adding lines to a generation
+ */
+type vfsBuilder struct {
+	names map[string]string // for interning node names
+	fresh map[pkgId]bool    // pkgvers that didn't own anything before
+}
+
+func (self *Gen) newBuilder() *vfsBuilder {
+	return &vfsBuilder{
+		names: make(map[string]string),
+		fresh: make(map[pkgId]bool),
+	}
+}
+
+func (self *vfsBuilder) intern(name string) string {
+	if interned, ok := self.names[name]; ok {
+		return interned
+	}
+	name = string([]byte(name))
+	self.names[name] = name
+	return name
+}
+
+/*
+ * get the id of pkgver, adding it to the package table if it isn't there
+ * the second return value is false if it was added
+ */
+func (self *Gen) pkgverId(pkgver Pkgver) (pkgId, bool) {
+	if pkg, ok := self.pkgIds[pkgver]; ok {
+		return pkg, true
+	}
+	pkgver = Pkgver([]byte(pkgver))
+	var pkg pkgId
+	if n := len(self.freePkgs); n > 0 {
+		pkg = self.freePkgs[n-1]
+		self.freePkgs = self.freePkgs[0 : n-1]
+		self.pkgvers[pkg] = pkgver
+	} else {
+		pkg = pkgId(len(self.pkgvers))
+		self.pkgvers = append(self.pkgvers, pkgver)
+	}
+	self.pkgIds[pkgver] = pkg
+	return pkg, false
+}
+
+/*
+ * remove a pkgver that doesn't own anything anymore from the package table
+ */
+func (self *Gen) removePkgver(pkg pkgId) {
+	delete(self.pkgIds, self.pkgvers[pkg])
+	self.pkgvers[pkg] = ""
+	self.freePkgs = append(self.freePkgs, pkg)
+}
+
+/*
+ * get the id of vtype, adding it to the table if it isn't there
+ * link targets are shared by every node that links to the same place
+ */
+func (self *Gen) vfsTypeId(vtype VfsType) vtypeId {
+	if vt, ok := self.vtypeIds[vtype]; ok {
+		return vt
+	}
+	vtype = VfsType([]byte(vtype))
+	vt := vtypeId(len(self.vtypes))
+	self.vtypes = append(self.vtypes, vtype)
+	self.vtypeIds[vtype] = vt
+	return vt
+}
+
+func (self *Gen) vfsGetOwner(vfs Vfs, pkg pkgId) VfsType {
+	for _, owner := range self.nodes[vfs].owners {
+		if owner.pkg == pkg {
+			return self.vtypes[owner.vtype]
+		}
+	}
+	return ""
+}
+
+/*
+ * set the type of vfs in pkg
+ *
+ * lines from the same package are added one after another, so if a fresh
+ * pkgver already owns the node it's the last owner and there's no need to
+ * look through all of them (which is slow for dirs like /usr)
+ */
+func (self *Gen) vfsSetOwner(b *vfsBuilder, vfs Vfs, pkg pkgId, vt vtypeId) {
+	node := &self.nodes[vfs]
+	if n := len(node.owners); n > 0 && node.owners[n-1].pkg == pkg {
+		node.owners[n-1].vtype = vt
+		return
+	}
+	if !b.fresh[pkg] {
+		for i := range node.owners {
+			if node.owners[i].pkg == pkg {
+				node.owners[i].vtype = vt
+				return
+			}
+		}
+	}
+	node.owners = append(node.owners, vfsOwner{pkg, vt})
+}
+
+func (self *Gen) vfsRemoveOwner(vfs Vfs, pkg pkgId) {
+	node := &self.nodes[vfs]
+	for i := range node.owners {
+		if node.owners[i].pkg == pkg {
+			owners := make([]vfsOwner, 0, len(node.owners)-1)
+			owners = append(owners, node.owners[0:i]...)
+			owners = append(owners, node.owners[i+1:]...)
+			node.owners = owners
+			return
+		}
+	}
+}
+
+func (self *Gen) vfsGetOrCreate(b *vfsBuilder, vfs Vfs, name string) Vfs {
+	if cvfs, ok := self.nodes[vfs].children[name]; ok {
+		return cvfs
+	}
+	name = b.intern(name)
+	var cvfs Vfs
+	if n := len(self.free); n > 0 {
+		cvfs = self.free[n-1]
+		self.free = self.free[0 : n-1]
+		self.nodes[cvfs] = vfsNode{name: name, parent: vfs}
+	} else {
+		cvfs = Vfs(len(self.nodes))
+		self.nodes = append(self.nodes, vfsNode{name: name, parent: vfs})
+	}
+	node := &self.nodes[vfs]
+	if node.children == nil {
+		node.children = make(map[string]Vfs)
+	}
+	node.children[name] = cvfs
+	if self.index != nil {
+		self.indexAdded = append(self.indexAdded, cvfs)
+	}
+	return cvfs
+}
+
+func (self *Gen) vfsRemove(vfs Vfs) {
+	parent := self.nodes[vfs].parent
+	delete(self.nodes[parent].children, self.nodes[vfs].name)
+	if len(self.nodes[parent].children) == 0 {
+		self.nodes[parent].children = nil
+	}
+	self.nodes[vfs] = vfsNode{parent: VFS_NONE}
+	self.free = append(self.free, vfs)
+	if self.index != nil {
+		self.indexRemoved += 1
+	}
+}
+
+/*
+ * trim the owner lists and the node slice to their lengths, drop link
+ * targets nothing points to anymore and count what each package owns
+ * call when done adding lines
+ */
+func (self *Gen) compact() {
+	self.pkgTypes = make([]VfsTypes, len(self.pkgvers))
+	used := make([]bool, len(self.vtypes))
+	used[vtypeDir] = true
+	used[vtypeFile] = true
+	for i := range self.nodes {
+		node := &self.nodes[i]
+		if cap(node.owners) > len(node.owners) {
+			owners := make([]vfsOwner, len(node.owners))
+			copy(owners, node.owners)
+			node.owners = owners
+		}
+		for _, owner := range node.owners {
+			used[owner.vtype] = true
+		}
+		if Vfs(i) == VFS_ROOT {
+			continue
+		}
+		for _, owner := range node.owners {
+			types := &self.pkgTypes[owner.pkg]
+			switch owner.vtype {
+			case vtypeDir:
+				types.Dir += 1
+			case vtypeFile:
+				types.File += 1
+			default:
+				types.Link += 1
+			}
+		}
+	}
+	if cap(self.nodes) > len(self.nodes) {
+		nodes := make([]vfsNode, len(self.nodes))
+		copy(nodes, self.nodes)
+		self.nodes = nodes
+	}
+	self.compactTypes(used)
+}
+
+/*
+ * rebuild the type table with only the used entries
+ * reloads would keep adding to it otherwise
+ */
+func (self *Gen) compactTypes(used []bool) {
+	unused := 0
+	for _, u := range used {
+		if !u {
+			unused += 1
+		}
+	}
+	if unused == 0 {
+		return
+	}
+	ids := make([]vtypeId, len(self.vtypes))
+	vtypes := make([]VfsType, 0, len(self.vtypes)-unused)
+	vtypeIds := make(map[VfsType]vtypeId, len(self.vtypes)-unused)
+	for vt, vtype := range self.vtypes {
+		if used[vt] {
+			ids[vt] = vtypeId(len(vtypes))
+			vtypeIds[vtype] = ids[vt]
+			vtypes = append(vtypes, vtype)
+		}
+	}
+	for i := range self.nodes {
+		for j := range self.nodes[i].owners {
+			owner := &self.nodes[i].owners[j]
+			owner.vtype = ids[owner.vtype]
+		}
+	}
+	self.vtypes = vtypes
+	self.vtypeIds = vtypeIds
+}
@@ -5,27 +5,69 @@
 package xldb
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-type Vfs map[string]*Vfs
+import "gitobj"
 
-type Xldb struct {
+/*
+ * one complete version of the database
+ *
+ * a new generation is built on every load and published with an atomic
+ * swap once it's complete, so it's never modified while readers can see it
+ */
+type Gen struct {
 	LastModified string // last-modified header
-	Repo         string // path to git repo
-
-	vfs_owners  map[*Vfs]map[Pkgver]VfsType
-	vfs_parents map[*Vfs]*Vfs
-	vfs_root    Vfs
-	loading     int32
-	mutex       sync.RWMutex
-	pkgs        map[string]string
+	Commit       string // commit in the git repo, empty if not loaded from one
+
+	nodes []vfsNode
+	free  []Vfs // removed nodes that can be reused
+	pkgs  map[string]string
+
+	pkgvers  []Pkgver // empty for removed ones
+	pkgIds   map[Pkgver]pkgId
+	freePkgs []pkgId
+	vtypes   []VfsType
+	vtypeIds map[VfsType]vtypeId
+	pkgTypes []VfsTypes // what each package owns, not counting the root
+
+	index        *pathIndex // nil if disabled
+	indexAdded   []Vfs      // nodes added since cloning, for updating the index
+	indexRemoved int
+
+	sonames     map[string][]SonameProvider
+	sonamesOnce sync.Once
+}
+
+type Xldb struct {
+	// load progress, 64-bit fields first for atomic access on 386
+	progress_lines int64
+	progress_pkgs  int64
+	progress_start int64 // unix nanoseconds
+	progress_end   int64
+
+	Repo         string    // path to git repo
+	SnapshotPath string    // where to save the tree for faster startup, empty to disable
+	PathIndex    bool      // keep a trigram index of paths for Search()
+	Log          io.Writer // where to say what it's doing
+
+	gen     atomic.Value // *Gen
+	loading int32
+
+	HistorySize int // max. number of old generations to keep for GetAt()
+	history     history
+
+	ChangesSize  int // max. number of change sets to keep for GetChanges()
+	changes      []*ChangeSet
+	changesMutex sync.Mutex
+
+	recentChanges changeCache
 }
 
 func getDefaultRepo() string {
@@ -45,31 +87,65 @@ func getDefaultRepo() string {
 	return repo
 }
 
-func (self *Xldb) Init() {
-	self.vfs_root = make(Vfs)
-	self.vfs_owners = make(map[*Vfs]map[Pkgver]VfsType)
-	self.vfs_parents = make(map[*Vfs]*Vfs)
-	self.vfs_parents[&self.vfs_root] = &self.vfs_root
-	self.vfs_owners[&self.vfs_root] = make(map[Pkgver]VfsType)
+func getDefaultSnapshotPath() string {
+	if path, ok := os.LookupEnv("VOIDFS_SNAPSHOT"); ok {
+		return path
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return cache + "/voidfs.snapshot"
+}
+
+func newGen() *Gen {
+	self := &Gen{}
+	// root is its own parent
+	self.nodes = []vfsNode{{parent: VFS_ROOT}}
 	self.pkgs = make(map[string]string)
-	self.Repo = getDefaultRepo()
+	self.pkgIds = make(map[Pkgver]pkgId)
+	self.vtypeIds = make(map[VfsType]vtypeId)
+	self.vfsTypeId(XLDB_DIR)
+	self.vfsTypeId(XLDB_FILE)
+	return self
 }
 
-func (self *Xldb) RLock() {
-	self.mutex.RLock()
+func (self *Xldb) Init() {
+	self.gen.Store(newGen())
+	self.Repo = getDefaultRepo()
+	self.SnapshotPath = getDefaultSnapshotPath()
+	self.PathIndex = true
+	self.Log = os.Stdout
+	self.HistorySize = 2
+	self.ChangesSize = 30
+	self.history.entries = make(map[gitobj.Hash]*historyEntry)
 }
 
-func (self *Xldb) RUnlock() {
-	self.mutex.RUnlock()
+/*
+ * get the current generation
+ *
+ * use the same one for everything in a request so the results are consistent
+ */
+func (self *Xldb) Get() *Gen {
+	return self.gen.Load().(*Gen)
 }
 
 const thecomma = ","
 const thearrow = " -> "
 
-func splitLine(line string) (pkgver Pkgver, path string, target string) {
+/*
+ * "pkgver,path[ -> target]" -> "pkgver", "path[ -> target]"
+ */
+func splitLine(line string) (pkgver string, rest string) {
 	comma := strings.Index(line, thecomma)
-	pkgver = Pkgver(line[0:comma])
-	path = line[comma+len(thecomma):]
+	return line[0:comma], line[comma+len(thecomma):]
+}
+
+/*
+ * "path[ -> target]" -> "path", "target"
+ */
+func splitTarget(rest string) (path string, target string) {
+	path = rest
 	if arrow := strings.Index(path, thearrow); arrow != -1 {
 		target = path[arrow+len(thearrow):]
 		path = path[0:arrow]
@@ -88,182 +164,279 @@ func splitPath(path string) []string {
 	return names
 }
 
-func (self *Xldb) getLastModified() (string, error) {
-	cmd := exec.Command("/bin/sh", "-c", `
-	set -e
-	s=$(git -C "$VOIDFS_REPO" log -1 --format=%at)
-	LC_ALL=C TZ=GMT date -d "@$s" +'%a, %d %b %Y %H:%M:%S %Z'
-	`)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("VOIDFS_REPO=%s", self.Repo))
-	cmd.Stderr = os.Stderr
-	out, err := cmd.Output()
-	if err != nil {
-		return "", err
+func formatLastModified(t time.Time) string {
+	return t.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+}
+
+/*
+ * returns false if another load is already running
+ * call endLoad() when done if this returned true
+ */
+func (self *Xldb) beginLoad() bool {
+	if atomic.AddInt32(&self.loading, 1) > 1 {
+		atomic.AddInt32(&self.loading, -1)
+		fmt.Fprintln(self.Log, "xldb: already loading")
+		return false
 	}
-	return strings.TrimSuffix(string(out), "\n"), nil
+	atomic.StoreInt64(&self.progress_lines, 0)
+	atomic.StoreInt64(&self.progress_pkgs, 0)
+	atomic.StoreInt64(&self.progress_start, time.Now().UnixNano())
+	atomic.StoreInt64(&self.progress_end, 0)
+	return true
+}
+
+func (self *Xldb) endLoad() {
+	atomic.StoreInt64(&self.progress_end, time.Now().UnixNano())
+	atomic.AddInt32(&self.loading, -1)
 }
 
-func (self *Xldb) Load() error {
+func (self *Xldb) isUpToDate(lastModified string) bool {
+	current := self.Get().LastModified
+	if current == "" {
+		return false
+	}
+	if lastModified == current {
+		fmt.Fprintln(self.Log, "xldb: already up-to-date")
+		return true
+	}
+	fmt.Fprintf(self.Log, "xldb: %s -> %s\n", current, lastModified)
+	return false
+}
 
-	defer atomic.AddInt32(&self.loading, -1)
-	if atomic.AddInt32(&self.loading, 1) > 1 {
-		fmt.Println("xldb: already loading")
-		return nil
+/*
+ * load the file list from the git repo in self.Repo
+ *
+ * returns what changed since the last load, or nil if this is the first
+ * load or nothing was loaded (already up-to-date or already loading)
+ */
+func (self *Xldb) Load() (*ChangeSet, error) {
+	if !self.beginLoad() {
+		return nil, nil
 	}
+	defer self.endLoad()
 
-	lastModified, err := self.getLastModified()
+	repo, err := gitobj.Open(self.Repo)
 	if err != nil {
-		return fmt.Errorf("failed to read date from xlocate repo: %s", err)
+		return nil, fmt.Errorf("failed to open xlocate repo: %s", err)
 	}
+	defer repo.Close()
 
-	updating := self.LastModified != ""
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD from xlocate repo: %s", err)
+	}
+	commit, err := repo.ReadCommit(head)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD from xlocate repo: %s", err)
+	}
+	lastModified := formatLastModified(commit.AuthorTime)
+
+	if self.isUpToDate(lastModified) {
+		return nil, nil
+	}
+
+	if self.Get().LastModified == "" && self.SnapshotPath != "" {
+		gen, err := readSnapshot(self.SnapshotPath, commit.Hash.String(), lastModified, &self.progress_pkgs)
+		if err == nil {
+			fmt.Fprintln(self.Log, "xldb: loaded snapshot")
+			self.indexGen(gen)
+			self.gen.Store(gen)
+			return nil, nil
+		} else if !os.IsNotExist(err) {
+			fmt.Fprintf(self.Log, "xldb: not using snapshot: %s\n", err)
+		}
+	}
 
-	if updating {
-		if lastModified == self.LastModified {
-			fmt.Println("xldb: already up-to-date")
-			return nil
+	var cs *ChangeSet
+	if old := self.Get(); old.Commit != "" {
+		cs, err = self.loadIncremental(repo, old, commit)
+		if err != nil {
+			fmt.Fprintf(self.Log, "xldb: incremental reload failed, doing a full one: %s\n", err)
 		}
-		fmt.Printf("xldb: %s -> %s\n", self.LastModified, lastModified)
-	}
-
-	cmd := exec.Command("/bin/sh", "-c", `
-	# -z: use null byte instead of colon for the delimiter
-	#     the version string of "telepathy-mission-control" contains a colon
-	# tr: (could this be removed? lua didn't like null bytes in strings)
-	git -C "$VOIDFS_REPO" grep -z '' @ | tr '\0' ',' | cut -b3- | uniq
-	`)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("VOIDFS_REPO=%s", self.Repo))
-	cmd.Stderr = os.Stderr
-	stdout, err := cmd.StdoutPipe()
+	}
+	if cs == nil {
+		src, err := newTreeScanner(repo, commit.Tree, self.Log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file list: %s", err)
+		}
+		cs, err = self.load(src, lastModified, commit.Hash.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if self.SnapshotPath != "" {
+		if err := writeSnapshot(self.Get(), self.SnapshotPath); err != nil {
+			fmt.Fprintf(self.Log, "xldb: failed to write snapshot: %s\n", err)
+		}
+	}
+
+	return cs, nil
+}
+
+/*
+ * load the file list from a stream of "pkgver,path[ -> target]" lines
+ *
+ * lines from the same package must be next to each other
+ * if lastModified is empty, the current time is used
+ */
+func (self *Xldb) LoadReader(r io.Reader, lastModified string) (*ChangeSet, error) {
+	if !self.beginLoad() {
+		return nil, nil
+	}
+	defer self.endLoad()
+
+	if lastModified == "" {
+		lastModified = formatLastModified(time.Now())
+	}
+
+	if self.isUpToDate(lastModified) {
+		return nil, nil
+	}
+
+	return self.load(newListScanner(r, self.Log), lastModified, "")
+}
+
+/*
+ * like LoadReader but reads from a file ("-" for stdin)
+ * the modification time of the file is used for LastModified
+ */
+func (self *Xldb) LoadFile(path string) (*ChangeSet, error) {
+	if path == "-" {
+		return self.LoadReader(os.Stdin, "")
+	}
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to read file list: %s", err)
+		return nil, fmt.Errorf("failed to read file list: %s", err)
 	}
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to read file list: %s", err)
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file list: %s", err)
 	}
+	return self.LoadReader(f, formatLastModified(st.ModTime()))
+}
 
-	pkgs := make(map[string]string)
+/*
+ * build a new generation from src and publish it
+ * the current one stays in use if this fails
+ */
+func (self *Xldb) load(src lineSource, lastModified string, commit string) (*ChangeSet, error) {
+	gen, err := buildGen(src, &self.progress_lines, &self.progress_pkgs)
+	if err != nil {
+		return nil, err
+	}
+	gen.LastModified = lastModified
+	gen.Commit = commit
 
-	var ppkgver Pkgver
-	skip := false
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		pkgver, path, target := splitLine(line)
-
-		if pkgver == ppkgver {
-			if skip {
-				continue
-			}
-			pkgver = ppkgver
-		} else {
-			pkgver = Pkgver([]byte(pkgver))
-		}
+	return self.publish(gen), nil
+}
+
+/*
+ * make gen the current generation
+ * returns what changed, or nil if there was nothing loaded before
+ */
+func (self *Xldb) publish(gen *Gen) *ChangeSet {
+	var cs *ChangeSet
+	if old := self.Get(); old.LastModified != "" {
+		cs = diffGens(old, gen)
+		printChanges(self.Log, cs)
+		self.addChanges(cs)
+	}
+
+	self.indexGen(gen)
+	self.gen.Store(gen)
+
+	return cs
+}
+
+func printChanges(w io.Writer, cs *ChangeSet) {
+	for _, change := range cs.Added {
+		fmt.Fprintf(w, "%s: new package\n", change.Name)
+	}
+	for _, change := range cs.Updated {
+		fmt.Fprintf(w, "%s: %s -> %s\n", change.Name, change.Old.Version(), change.New.Version())
+	}
+	for _, change := range cs.Removed {
+		fmt.Fprintf(w, "%s: removed package\n", change.Name)
+	}
+}
+
+/*
+ * build a generation from src
+ * lines and pkgs are incremented as they're read to show the progress
+ */
+func buildGen(src lineSource, lines *int64, pkgs *int64) (*Gen, error) {
+	gen := newGen()
+	if err := gen.addLines(src, lines, pkgs); err != nil {
+		return nil, err
+	}
+	return gen, nil
+}
 
-		self.mutex.Lock()
+/*
+ * add everything from src to a generation that isn't published yet
+ */
+func (self *Gen) addLines(src lineSource, lines *int64, pkgs *int64) error {
+	b := self.newBuilder()
+
+	var ppkgver Pkgver
+	var pkg pkgId
+	for src.Scan() {
+		pkgver := Pkgver(src.Pkgver())
+		path, target := splitTarget(src.Line())
+		atomic.AddInt64(lines, 1)
 
 		if pkgver != ppkgver {
+			var existed bool
+			pkg, existed = self.pkgverId(pkgver)
+			pkgver = self.pkgvers[pkg]
 			pkgname, version := pkgver.Split()
-			pkgs[pkgname] = version
+			self.pkgs[pkgname] = version
 			ppkgver = pkgver
-			if updating {
-				if version == self.pkgs[pkgname] {
-					// unchanged
-					skip = true
-				} else if self.pkgs[pkgname] != "" {
-					// updated
-					skip = false
-					fmt.Printf("%s: %s -> %s\n", pkgname, self.pkgs[pkgname], version)
-					self.vfsEradicatePkgver(&self.vfs_root,
-						JoinPkgver(pkgname, self.pkgs[pkgname]))
-				} else {
-					// added
-					skip = false
-					fmt.Printf("%s: new package\n", pkgname)
-				}
-				if skip {
-					self.mutex.Unlock()
-					continue
-				}
-				// removed packages are checked later
-			}
-			self.vfs_owners[&self.vfs_root][pkgver] = XLDB_DIR
+			atomic.AddInt64(pkgs, 1)
+			// a pkgver that was already in the table may own things (its
+			// lines don't have to be next to each other)
+			b.fresh[pkg] = !existed
+			self.vfsSetOwner(b, VFS_ROOT, pkg, vtypeDir)
 		}
 
-		vfs := &self.vfs_root
+		vfs := VFS_ROOT
 		components := splitPath(path)
 		for i, name := range components {
-			var cvtype VfsType
+			var cvt vtypeId
 			if i < len(components)-1 {
-				cvtype = XLDB_DIR
+				cvt = vtypeDir
 			} else if target == "" {
-				cvtype = XLDB_FILE
+				cvt = vtypeFile
 			} else {
-				cvtype = VfsType([]byte(target))
+				cvt = self.vfsTypeId(VfsType(target))
 			}
-			cvfs := self.vfsGetOrCreate(vfs, name)
-			self.vfs_owners[cvfs][pkgver] = cvtype
+			cvfs := self.vfsGetOrCreate(b, vfs, name)
+			self.vfsSetOwner(b, cvfs, pkg, cvt)
 			vfs = cvfs
 		}
-
-		self.mutex.Unlock()
-	}
-
-	if updating {
-		// check for removed packages
-		for pkgname, version := range self.pkgs {
-			if pkgs[pkgname] == "" {
-				fmt.Printf("%s: removed package\n", pkgname)
-				self.mutex.Lock()
-				self.vfsEradicatePkgver(&self.vfs_root,
-					JoinPkgver(pkgname, version))
-				self.mutex.Unlock()
-			}
-		}
 	}
-	self.pkgs = pkgs
-
-	// only update this after we're done so browsers don't cache inconsistent results
-	self.LastModified = lastModified
-
-	// don't return errors on these since we already updated the database
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("xldb: %s\n", err)
-	}
-	if err := cmd.Wait(); err != nil {
-		fmt.Printf("xldb: %s\n", err)
+	self.compact()
+	if err := src.Err(); err != nil {
+		return fmt.Errorf("failed to read file list: %s", err)
 	}
 
 	return nil
 }
 
-func (self *Xldb) vfsEradicatePkgver(vfs *Vfs, pkgver Pkgver) {
-	vtype := self.vfs_owners[vfs][pkgver]
+func (self *Gen) vfsEradicatePkgver(vfs Vfs, pkg pkgId) {
+	vtype := self.vfsGetOwner(vfs, pkg)
 	if !vtype.Ok() {
 		return
 	}
 	if vtype.IsDir() {
-		for _, cvfs := range *vfs {
-			self.vfsEradicatePkgver(cvfs, pkgver)
+		for _, cvfs := range self.nodes[vfs].children {
+			self.vfsEradicatePkgver(cvfs, pkg)
 		}
 	}
-	delete(self.vfs_owners[vfs], pkgver) // a
-	if len(self.vfs_owners[vfs]) == 0 && vfs != &self.vfs_root {
-		parent := self.VfsGetParent(vfs)
-		name := self.VfsGetName(vfs)
-		delete(*parent, name)
-		delete(self.vfs_owners, vfs) // b
-		delete(self.vfs_parents, vfs)
-	}
-}
-
-func (self *Xldb) vfsGetOrCreate(vfs *Vfs, name string) *Vfs {
-	cvfs := (*vfs)[name]
-	if cvfs == nil {
-		cvfs = &Vfs{}
-		(*vfs)[string([]byte(name))] = cvfs
-		self.vfs_owners[cvfs] = make(map[Pkgver]VfsType)
-		self.vfs_parents[cvfs] = vfs
+	self.vfsRemoveOwner(vfs, pkg)
+	if len(self.nodes[vfs].owners) == 0 && vfs != VFS_ROOT {
+		self.vfsRemove(vfs)
 	}
-	return cvfs
 }
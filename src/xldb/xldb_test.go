@@ -0,0 +1,90 @@
+package xldb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// a small file list with dirs, files, links and the same path in several packages
+const testList = `base-files-0.1_1,/bin -> usr/bin
+base-files-0.1_1,/sbin -> usr/sbin
+base-files-0.1_1,/lib -> usr/lib
+base-files-0.1_1,/usr/bin/.keep
+base-files-0.1_1,/etc/hosts
+coreutils-9.0_1,/usr/bin/ls
+coreutils-9.0_1,/usr/bin/cat
+coreutils-9.0_1,/usr/bin/[ -> test
+coreutils-9.0_1,/usr/bin/test
+coreutils-9.0_1,/usr/share/man/man1/ls.1
+glibc-2.32_1,/usr/lib/libc.so.6
+glibc-2.32_1,/usr/lib/libm.so.6
+glibc-2.32_1,/usr/include/stdio.h
+glibc-2.32_1,/usr/bin/ldd
+glibc-2.32_1,/lib
+glibc-2.32_1,/usr/lib/libc.so -> libc.so.6
+openssl-3.0.0_1,/usr/lib/libssl.so.3
+openssl-3.0.0_1,/usr/lib/libcrypto.so.3
+openssl-3.0.0_1,/usr/bin/openssl
+openssl-devel-3.0.0_1,/usr/lib/libssl.so -> libssl.so.3
+openssl-devel-3.0.0_1,/usr/include/openssl/ssl.h
+openssl-devel-3.0.0_1,/usr/lib/pkgconfig/openssl.pc
+zlib-1.2.11_1,/usr/lib/libz.so.1.2.11
+zlib-1.2.11_1,/usr/lib/libz.so.1 -> libz.so.1.2.11
+zlib-1.2.11_1,/usr/lib/weird dir/a&b<c>.txt
+zlib-devel-1.2.11_1,/usr/lib/libz.so -> libz.so.1.2.11
+zlib-devel-1.2.11_1,/usr/include/zlib.h
+zlib-devel-1.2.11_1,/usr/lib/pkgconfig/zlib.pc
+`
+
+func testGen(t *testing.T, list string) *Gen {
+	t.Helper()
+	var lines, pkgs int64
+	gen, err := buildGen(newListScanner(strings.NewReader(list), ioutil.Discard), &lines, &pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gen
+}
+
+/*
+ * every path with its owners, one per line in sorted order, for comparing
+ * generations built in different ways
+ */
+func dumpGen(gen *Gen) string {
+	var rv []string
+	var walk func(vfs Vfs, path string)
+	walk = func(vfs Vfs, path string) {
+		var owners []string
+		for _, owner := range gen.VfsGetOwners(vfs) {
+			owners = append(owners, fmt.Sprintf("%s=%s", owner.Pkgver, owner.Type))
+		}
+		sort.Strings(owners)
+		rv = append(rv, fmt.Sprintf("%s %v", path, owners))
+		for name, cvfs := range gen.nodes[vfs].children {
+			walk(cvfs, path+"/"+name)
+		}
+	}
+	walk(VFS_ROOT, "")
+	sort.Strings(rv)
+	return strings.Join(rv, "\n")
+}
+
+func testGit(t *testing.T, dir string, env []string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(cmd.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=test",
+		"GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test",
+		"GIT_COMMITTER_EMAIL=test@example.com"), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+}